@@ -0,0 +1,145 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/odpf/raccoon/clockskew"
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/config"
+	"github.com/odpf/raccoon/conversion"
+	"github.com/odpf/raccoon/dedup"
+	"github.com/odpf/raccoon/enrichment"
+	"github.com/odpf/raccoon/filter"
+	"github.com/odpf/raccoon/publisher"
+	"github.com/odpf/raccoon/redaction"
+	"github.com/odpf/raccoon/sampling"
+	"github.com/odpf/raccoon/schema"
+	"github.com/odpf/raccoon/timeliness"
+	"github.com/odpf/raccoon/validation"
+)
+
+// stages holds the collector stages controlled at runtime, nil when not configured.
+type stages struct {
+	filter  *filter.Filter
+	sampler *sampling.Sampler
+	// redactor reloads its key in the background
+	redactor *redaction.Redactor
+}
+
+// middlewares returns the stages every request goes through before being buffered: the event counters, then the
+// stages of COLLECTOR_MIDDLEWARES.
+func middlewares() ([]collection.Middleware, stages) {
+	var s stages
+	chain := []collection.Middleware{collection.CountEvents}
+	// retyping is the stage listed so far which may publish events with another type than theirs
+	var retyping string
+	for _, name := range config.Collector.Middlewares {
+		switch name {
+		case "skip_empty":
+			chain = append(chain, collection.SkipEmpty)
+		case "dedup":
+			chain = append(chain, dedup.Middleware(dedupStore(), config.Collector.DedupTTL))
+		case "filter":
+			s.filter = filter.New(filter.Rules{
+				Allow: config.Collector.FilterAllow,
+				Deny:  config.Collector.FilterDeny,
+			}, filter.Action(config.Collector.FilterAction), config.Collector.FilterQuarantineType)
+			chain = append(chain, s.filter.Middleware)
+			retyping = name
+		case "json":
+			j, err := conversion.NewJSONToProto(schemaRegistry(), config.Collector.JSONEventTypes)
+			if err != nil {
+				panic(fmt.Sprintf("key COLLECTOR_JSON_EVENT_TYPES should only list types of COLLECTOR_SCHEMA_MESSAGES: %v", err))
+			}
+			chain = append(chain, j.Middleware)
+		case "validate":
+			v := validation.New(schemaRegistry(), config.Collector.ValidationRequireFields, filter.Action(config.Collector.ValidationAction), config.Collector.ValidationQuarantineType)
+			chain = append(chain, v.Middleware)
+			if config.Collector.ValidationAction == string(filter.ActionQuarantine) {
+				retyping = name
+			}
+		case "redact":
+			if retyping != "" {
+				// the fields are redacted by type, the retyped events would be published unredacted
+				panic(fmt.Sprintf("key COLLECTOR_MIDDLEWARES should list redact before %s, which may change the type of the events", retyping))
+			}
+			r, err := redaction.New(schemaRegistry(), config.Collector.RedactFields, config.Collector.RedactKeyFile)
+			if err != nil {
+				panic(fmt.Sprintf("keys COLLECTOR_REDACT_FIELDS and COLLECTOR_REDACT_KEY_FILE should be valid: %v", err))
+			}
+			s.redactor = r
+			chain = append(chain, r.Middleware)
+		case "enrich":
+			chain = append(chain, enricher().Middleware)
+		case "clock_skew":
+			chain = append(chain, clockskew.New(config.Collector.ClockSkewThreshold).Middleware)
+		case "timeliness":
+			chain = append(chain, timeliness.Policy{
+				MaxAge:       config.Collector.TimelinessMaxAge,
+				StaleAction:  timeliness.Action(config.Collector.TimelinessStaleAction),
+				MaxAhead:     config.Collector.TimelinessMaxAhead,
+				FutureAction: timeliness.Action(config.Collector.TimelinessFutureAction),
+				RerouteType:  config.Collector.TimelinessRerouteType,
+			}.Middleware)
+			if config.Collector.TimelinessStaleAction == string(timeliness.ActionReroute) || config.Collector.TimelinessFutureAction == string(timeliness.ActionReroute) {
+				retyping = name
+			}
+		case "sample":
+			s.sampler = sampling.New(config.Collector.SampleRates)
+			chain = append(chain, s.sampler.Middleware)
+		default:
+			panic(fmt.Sprintf("key COLLECTOR_MIDDLEWARES should list known stages, got %s", name))
+		}
+	}
+	return chain, s
+}
+
+// registry is shared by the stages and the publisher, loaded on first use.
+var registry *schema.Registry
+
+func schemaRegistry() *schema.Registry {
+	if registry != nil {
+		return registry
+	}
+	r, err := schema.Load(config.Collector.SchemaDescriptorFile, config.Collector.SchemaMessages)
+	if err != nil {
+		panic(fmt.Sprintf("key COLLECTOR_SCHEMA_DESCRIPTOR_FILE should be a FileDescriptorSet holding COLLECTOR_SCHEMA_MESSAGES: %v", err))
+	}
+	registry = r
+	return registry
+}
+
+// jsonFormatter returns the formatter rendering PUBLISHER_KAFKA_JSON_EVENT_TYPES, nil when not configured.
+func jsonFormatter() publisher.Formatter {
+	if len(config.PublisherKafka.JSONEventTypes) == 0 {
+		return nil
+	}
+	f, err := conversion.NewProtoToJSON(schemaRegistry(), config.PublisherKafka.JSONEventTypes)
+	if err != nil {
+		panic(fmt.Sprintf("key PUBLISHER_KAFKA_JSON_EVENT_TYPES should only list types of COLLECTOR_SCHEMA_MESSAGES: %v", err))
+	}
+	return f
+}
+
+func enricher() *enrichment.Enricher {
+	var geo *enrichment.GeoDB
+	if config.Collector.EnrichGeoDatabase != "" {
+		var err error
+		geo, err = enrichment.OpenGeoDB(config.Collector.EnrichGeoDatabase)
+		if err != nil {
+			panic(fmt.Sprintf("key COLLECTOR_ENRICH_GEO_DATABASE should be a MaxMind database: %v", err))
+		}
+	}
+	e, err := enrichment.New(config.Collector.EnrichTrustedProxies, geo)
+	if err != nil {
+		panic(fmt.Sprintf("key COLLECTOR_ENRICH_TRUSTED_PROXIES should list addresses and CIDR ranges: %v", err))
+	}
+	return e
+}
+
+func dedupStore() dedup.Store {
+	if config.Collector.DedupRedisAddr == "" {
+		return dedup.NewMemoryStore()
+	}
+	return dedup.NewRedisStore(config.Collector.DedupRedisAddr, config.Collector.DedupRedisPassword, config.Collector.DedupRedisPoolSize, config.Collector.DedupRedisTimeout)
+}
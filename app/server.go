@@ -6,41 +6,112 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/odpf/raccoon/collection"
 	"github.com/odpf/raccoon/config"
+	"github.com/odpf/raccoon/identification"
 	"github.com/odpf/raccoon/logger"
 	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
 	"github.com/odpf/raccoon/publisher"
+	"github.com/odpf/raccoon/recovery"
 	"github.com/odpf/raccoon/services"
+	"github.com/odpf/raccoon/services/admin"
 	"github.com/odpf/raccoon/worker"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // StartServer starts the server
 func StartServer(ctx context.Context, cancel context.CancelFunc) {
 	bufferChannel := make(chan collection.CollectRequest, config.Worker.ChannelSize)
-	httpServices := services.Create(bufferChannel)
-	logger.Info("Start Server -->")
-	httpServices.Start(ctx, cancel)
+	backpressure := collection.Backpressure{
+		Policy:  collection.BackpressurePolicy(config.Worker.BufferPolicy),
+		Timeout: config.Worker.BufferTimeout,
+	}
+	collector := collection.NewChannelCollectorWithBackpressure(bufferChannel, backpressure)
+	var fairQueue *collection.FairQueue
+	if config.Worker.GroupQueueSize > 0 {
+		fairQueue = collection.NewFairQueue(bufferChannel, config.Worker.GroupQueueSize, config.Worker.GroupWeights, config.Worker.GroupDefaultWeight, backpressure)
+		go fairQueue.Run()
+		go fairQueue.ReportStats(config.MetricStatsd.FlushPeriodMs)
+		collector = fairQueue
+	}
+	var priorityChannel chan collection.CollectRequest
+	if len(config.Worker.PriorityEventTypes) > 0 {
+		priorityChannel = make(chan collection.CollectRequest, config.Worker.PriorityChannelSize)
+		collector = collection.NewPriorityCollector(config.Worker.PriorityEventTypes, collection.NewChannelCollectorWithBackpressure(priorityChannel, backpressure), collector)
+	}
+	// replayed requests went through the middlewares before being persisted, with their enrichment
+	buffer := collector
+	chain, stages := middlewares()
+	collector = collection.Chain(collector, chain...)
 	logger.Info("Start publisher -->")
-	kPublisher, err := publisher.NewKafka()
+	kPublisher, err := publisher.NewKafkaPool()
 	if err != nil {
 		logger.Error("Error creating kafka producer", err)
 		logger.Info("Exiting server")
 		os.Exit(0)
 	}
+	if stages.sampler != nil {
+		kPublisher.EnableSampleRateHeader(stages.sampler.Rate)
+	}
+	if f := jsonFormatter(); f != nil {
+		kPublisher.EnableFormatter(f)
+	}
 
 	logger.Info("Start worker -->")
-	workerPool := worker.CreateWorkerPool(config.Worker.WorkersPoolSize, bufferChannel, config.Worker.DeliveryChannelSize, kPublisher)
+	workerPool := worker.CreateWorkerPool(config.Worker.WorkersPoolSize, bufferChannel, config.Worker.DeliveryChannelSize, config.Worker.DeliveryTimeout, kPublisher)
+	if config.Worker.CoalesceLinger > 0 {
+		workerPool.EnableCoalescing(worker.CoalescingConfig{
+			MaxEvents: config.Worker.CoalesceMaxEvents,
+			MaxBytes:  config.Worker.CoalesceMaxBytes,
+			Linger:    config.Worker.CoalesceLinger,
+		})
+	}
+	if config.Worker.QuarantineFile != "" {
+		workerPool.EnableQuarantine(config.Worker.QuarantineFile)
+	}
+	if priorityChannel != nil {
+		workerPool.EnablePriorityLane(priorityChannel, config.Worker.PriorityPoolSize)
+	}
+	if backpressure.Policy == collection.PolicyBlock && backpressure.Timeout == 0 {
+		workerPool.RefusePause()
+	}
+	if config.Worker.Sharded {
+		workerPool.EnableSharding(config.Worker.ShardChannelSize)
+	}
+	workerPool.EnableAutoscaling(worker.ScalingConfig{
+		MinSize:            config.Worker.WorkersPoolMinSize,
+		MaxSize:            config.Worker.WorkersPoolMaxSize,
+		Interval:           config.Worker.ScaleInterval,
+		ScaleUpOccupancy:   config.Worker.ScaleUpBufferPercent,
+		ScaleDownOccupancy: config.Worker.ScaleDownBufferPercent,
+		ScaleUpLatency:     config.Worker.ScaleUpLatency,
+	})
+	var eventTypes admin.EventTypes
+	if stages.filter != nil {
+		eventTypes = stages.filter
+	}
+	httpServices := services.Create(collector, workerPool, eventTypes)
+	logger.Info("Start Server -->")
+	httpServices.Start(ctx, cancel)
 	workerPool.StartWorkers()
+	if config.Worker.RecoveryFile != "" {
+		go replay(ctx, buffer)
+	}
+	if stages.redactor != nil {
+		go stages.redactor.WatchKey(ctx, config.Collector.RedactKeyReload)
+	}
 	go kPublisher.ReportStats()
 	go reportProcMetrics()
-	go shutDownServer(ctx, cancel, httpServices, bufferChannel, workerPool, kPublisher)
+	go shutDownServer(ctx, cancel, httpServices, bufferChannel, priorityChannel, fairQueue, workerPool, kPublisher)
 }
 
-func shutDownServer(ctx context.Context, cancel context.CancelFunc, httpServices services.Services, bufferChannel chan collection.CollectRequest, workerPool *worker.Pool, kp *publisher.Kafka) {
+func shutDownServer(ctx context.Context, cancel context.CancelFunc, httpServices services.Services, bufferChannel, priorityChannel chan collection.CollectRequest, fairQueue *collection.FairQueue, workerPool *worker.Pool, kp *publisher.Pool) {
 	signalChan := make(chan os.Signal)
 	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	for {
@@ -50,20 +121,28 @@ func shutDownServer(ctx context.Context, cancel context.CancelFunc, httpServices
 			logger.Info(fmt.Sprintf("[App.Server] Received a signal %s", sig))
 			httpServices.Shutdown(ctx)
 			logger.Info("Server shutdown all the listeners")
-			timedOut := workerPool.FlushWithTimeOut(config.Worker.WorkerFlushTimeout)
+			// the batches held by the fair queue are handed to the workers before they are told to stop once the
+			// channel is empty, within the same timeout
+			deadline := time.Now().Add(config.Worker.WorkerFlushTimeout)
+			var unprocessed []collection.CollectRequest
+			if fairQueue != nil {
+				unprocessed = fairQueue.Flush(config.Worker.WorkerFlushTimeout)
+			}
+			timedOut := workerPool.FlushWithTimeOut(time.Until(deadline))
 			if timedOut {
 				logger.Info(fmt.Sprintf("WorkerPool flush timedout %t", timedOut))
 			}
 			flushInterval := config.PublisherKafka.FlushInterval
 			logger.Info("Closing Kafka producer")
 			logger.Info(fmt.Sprintf("Wait %d ms for all messages to be delivered", flushInterval))
-			eventsInProducer := kp.Close()
-			/**
-			@TODO - should compute the actual no., of events per batch and therefore the total. We can do this only when we close all the active connections
-			Until then we fall back to approximation */
-			eventsInChannel := len(bufferChannel) * 7
-			logger.Info(fmt.Sprintf("Outstanding unprocessed events in the channel, data lost ~ (No batches %d * 5 events) = ~%d", len(bufferChannel), eventsInChannel))
-			metrics.Count("kafka_messages_delivered_total", eventsInChannel+eventsInProducer, "success=false")
+			unprocessed = append(unprocessed, collection.DrainChannel(bufferChannel)...)
+			unprocessed = append(unprocessed, collection.DrainChannel(priorityChannel)...)
+			unprocessed = append(unprocessed, workerPool.Unprocessed()...)
+			logger.Info(fmt.Sprintf("Outstanding unprocessed events in the channel : %d", countEvents(unprocessed)))
+			messagesInProducer := kp.Close()
+			undelivered := undeliveredRequests(kp.InFlight())
+			logger.Info(fmt.Sprintf("Outstanding undelivered events in the producer : %d in %d messages", countEvents(undelivered), messagesInProducer))
+			persistUnprocessed(append(unprocessed, undelivered...))
 			logger.Info("Exiting server")
 			cancel()
 		default:
@@ -72,6 +151,85 @@ func shutDownServer(ctx context.Context, cancel context.CancelFunc, httpServices
 	}
 }
 
+// replay collects the events persisted on the previous shutdown.
+func replay(ctx context.Context, collector collection.Collector) {
+	events, err := recovery.Replay(ctx, config.Worker.RecoveryFile, collector)
+	if err != nil {
+		logger.Errorf("[App.Server] failed to replay %s after %d events: %v", config.Worker.RecoveryFile, events, err)
+		return
+	}
+	if events > 0 {
+		logger.Info(fmt.Sprintf("[App.Server] Replayed %d events from %s", events, config.Worker.RecoveryFile))
+	}
+}
+
+// undeliveredRequests groups the events left in the producer into one request per connection group and headers, the
+// headers becoming the enrichment of the request.
+func undeliveredRequests(events []publisher.InFlightEvent) []collection.CollectRequest {
+	byKey := make(map[string]*pb.SendEventRequest)
+	var requests []collection.CollectRequest
+	for _, e := range events {
+		key := e.ConnGroup + "\x00" + headersKey(e.Headers)
+		req, ok := byKey[key]
+		if !ok {
+			req = &pb.SendEventRequest{SentTime: timestamppb.Now()}
+			byKey[key] = req
+			requests = append(requests, collection.CollectRequest{
+				ConnectionIdentifier: identification.Identifier{ID: recovery.ConnID, Group: e.ConnGroup},
+				Enrichment:           e.Headers,
+				SendEventRequest:     req,
+			})
+		}
+		req.Events = append(req.Events, e.Event)
+	}
+	return requests
+}
+
+// headersKey returns the same key for equal headers.
+func headersKey(headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%q:%q,", key, headers[key])
+	}
+	return b.String()
+}
+
+func countEvents(requests []collection.CollectRequest) int {
+	events := 0
+	for _, req := range requests {
+		events += len(req.GetEvents())
+	}
+	return events
+}
+
+// persistUnprocessed writes the events which could not be published to the recovery file, if configured.
+// Events not persisted are reported lost.
+func persistUnprocessed(requests []collection.CollectRequest) {
+	if len(requests) == 0 {
+		return
+	}
+	bucket, status := "kafka_messages_delivered_total", "success=false,"
+	if config.Worker.RecoveryFile != "" {
+		events, err := recovery.Persist(config.Worker.RecoveryFile, requests)
+		if err != nil {
+			logger.Errorf("[App.Server] failed to persist unprocessed events to %s: %v", config.Worker.RecoveryFile, err)
+		} else {
+			logger.Info(fmt.Sprintf("[App.Server] Persisted %d unprocessed events to %s", events, config.Worker.RecoveryFile))
+			bucket, status = "events_persisted_total", ""
+		}
+	}
+	for _, req := range requests {
+		for _, e := range req.GetEvents() {
+			metrics.Increment(bucket, fmt.Sprintf("%sconn_group=%s,event_type=%s", status, req.ConnectionIdentifier.Group, e.Type))
+		}
+	}
+}
+
 func reportProcMetrics() {
 	t := time.Tick(config.MetricStatsd.FlushPeriodMs)
 	m := &runtime.MemStats{}
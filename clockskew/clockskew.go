@@ -0,0 +1,113 @@
+package clockskew
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// HeaderClockOffset is the offset of the clock of the client in milliseconds, positive when the client is behind.
+const HeaderClockOffset = "raccoon-clock-offset-ms"
+
+// estimateWindow is how long the offset of a connection is estimated from its requests before starting over, so that
+// a clock set right again is noticed.
+var estimateWindow = 10 * time.Minute
+
+// Detector is a collector stage estimating how far the clock of each connection is from the one of the server. A
+// request tells the offset plus the network latency, the time it was received minus its SentTime, so the offset of a
+// connection is estimated as the lowest of its requests. Only offsets beyond a threshold are taken as skewed clocks.
+type Detector struct {
+	threshold time.Duration
+
+	m         sync.Mutex
+	estimates map[identification.Identifier]*estimate
+	// pruned is when the estimates of the connections gone were last removed
+	pruned time.Time
+}
+
+// estimate is the offset of the clock of a connection.
+type estimate struct {
+	offset time.Duration
+	// since is when the estimate started, seen when it was last updated
+	since time.Time
+	seen  time.Time
+}
+
+// New creates a detector taking the offsets beyond threshold, either way, as skewed clocks.
+func New(threshold time.Duration) *Detector {
+	return &Detector{threshold: threshold, estimates: make(map[identification.Identifier]*estimate)}
+}
+
+// Offset returns the offset of the clock of the client which sent req, network latency included, false when its
+// SentTime is not set.
+func Offset(req *collection.CollectRequest) (time.Duration, bool) {
+	if req.GetSentTime() == nil || req.TimeConsumed.IsZero() {
+		return 0, false
+	}
+	return req.TimeConsumed.Sub(req.GetSentTime().AsTime()), true
+}
+
+// estimate updates the estimated offset of the connection of req with offset. Returns the estimate, and true when the
+// estimate just started.
+func (d *Detector) estimate(req *collection.CollectRequest, offset time.Duration) (time.Duration, bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	now := req.TimeConsumed
+	if now.Sub(d.pruned) > estimateWindow {
+		for id, e := range d.estimates {
+			if now.Sub(e.seen) > estimateWindow {
+				delete(d.estimates, id)
+			}
+		}
+		d.pruned = now
+	}
+	e, ok := d.estimates[req.ConnectionIdentifier]
+	if !ok || now.Sub(e.since) > estimateWindow {
+		d.estimates[req.ConnectionIdentifier] = &estimate{offset: offset, since: now, seen: now}
+		return offset, true
+	}
+	e.seen = now
+	if offset < e.offset {
+		e.offset = offset
+	}
+	return e.offset, false
+}
+
+// Middleware adds the estimated clock offset of its connection to the enrichment of every request. The offset of a
+// skewed clock is set as the ClockOffset of the request, the request itself is left as sent.
+func (d *Detector) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		measured, ok := Offset(req)
+		if !ok {
+			return next.Collect(ctx, req)
+		}
+		offset, started := d.estimate(req, measured)
+		group := req.ConnectionIdentifier.Group
+		if req.Enrichment == nil {
+			req.Enrichment = make(map[string]string, 1)
+		}
+		req.Enrichment[HeaderClockOffset] = strconv.FormatInt(offset.Milliseconds(), 10)
+		skew := offset
+		if skew < 0 {
+			skew = -skew
+		}
+		if started {
+			metrics.Timing("clock_offset_milliseconds", skew.Milliseconds(), "conn_group="+group)
+		}
+		if skew > d.threshold {
+			direction := "behind"
+			if offset < 0 {
+				direction = "ahead"
+			}
+			metrics.Increment("clock_skewed_batches_total", fmt.Sprintf("conn_group=%s,direction=%s", group, direction))
+			req.ClockOffset = offset
+		}
+		return next.Collect(ctx, req)
+	})
+}
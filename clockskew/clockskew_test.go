@@ -0,0 +1,87 @@
+package clockskew
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func requestSentAt(connID string, sent, consumed time.Time) *collection.CollectRequest {
+	return &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: connID, Group: "viewer"},
+		TimeConsumed:         consumed,
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc", SentTime: timestamppb.New(sent)},
+	}
+}
+
+func TestDetector_Middleware(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := &collection.MockCollector{}
+	c.On("Collect", ctx, mock.Anything).Return(nil)
+
+	t.Run("Should add the offset of a clock in sync", func(t *testing.T) {
+		d := New(time.Minute).Middleware(c)
+		sent := now.Add(-150 * time.Millisecond)
+		req := requestSentAt("1", sent, now)
+		assert.NoError(t, d.Collect(ctx, req))
+		assert.Equal(t, map[string]string{HeaderClockOffset: "150"}, req.Enrichment)
+		assert.Equal(t, time.Duration(0), req.ClockOffset)
+		assert.True(t, sent.Equal(req.GetSentTime().AsTime()))
+	})
+
+	t.Run("Should set the offset of a skewed clock and leave the sent time", func(t *testing.T) {
+		d := New(time.Minute).Middleware(c)
+		for sent, offset := range map[time.Time]time.Duration{now.Add(-3 * time.Hour): 3 * time.Hour, now.Add(2 * time.Hour): -2 * time.Hour} {
+			req := requestSentAt(sent.String(), sent, now)
+			req.Enrichment = map[string]string{"raccoon-ua-os": "iOS"}
+			assert.NoError(t, d.Collect(ctx, req))
+			assert.Equal(t, "iOS", req.Enrichment["raccoon-ua-os"])
+			assert.Equal(t, offset, req.ClockOffset)
+			assert.True(t, sent.Equal(req.GetSentTime().AsTime()))
+		}
+	})
+
+	t.Run("Should estimate the offset of a connection from its fastest request", func(t *testing.T) {
+		d := New(time.Minute).Middleware(c)
+		for i, latency := range []time.Duration{300, 100, 200} {
+			consumed := now.Add(time.Duration(i) * time.Second)
+			req := requestSentAt("1", consumed.Add(-latency*time.Millisecond), consumed)
+			assert.NoError(t, d.Collect(ctx, req))
+			assert.Equal(t, []string{"300", "100", "100"}[i], req.Enrichment[HeaderClockOffset])
+		}
+		other := requestSentAt("2", now.Add(-400*time.Millisecond), now)
+		assert.NoError(t, d.Collect(ctx, other))
+		assert.Equal(t, "400", other.Enrichment[HeaderClockOffset])
+
+		later := now.Add(estimateWindow + time.Second)
+		req := requestSentAt("1", later.Add(-250*time.Millisecond), later)
+		assert.NoError(t, d.Collect(ctx, req))
+		assert.Equal(t, "250", req.Enrichment[HeaderClockOffset])
+	})
+
+	t.Run("Should forget the connections gone", func(t *testing.T) {
+		d := New(time.Minute)
+		next := d.Middleware(c)
+		assert.NoError(t, next.Collect(ctx, requestSentAt("1", now, now)))
+		later := now.Add(2 * estimateWindow)
+		assert.NoError(t, next.Collect(ctx, requestSentAt("2", later, later)))
+		assert.Len(t, d.estimates, 1)
+	})
+
+	t.Run("Should leave the requests without sent time", func(t *testing.T) {
+		d := New(time.Minute).Middleware(c)
+		req := &collection.CollectRequest{TimeConsumed: now, SendEventRequest: &pb.SendEventRequest{}}
+		assert.NoError(t, d.Collect(ctx, req))
+		assert.Nil(t, req.Enrichment)
+		_, ok := Offset(req)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,79 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/odpf/raccoon/metrics"
+)
+
+// ErrBufferFull is returned when a request is rejected because the buffer is full. The request can be retried later.
+var ErrBufferFull = errors.New("buffer is full")
+
+// BackpressurePolicy tells what to do with a request when the buffer is full.
+type BackpressurePolicy string
+
+const (
+	// PolicyBlock waits for room in the buffer until the timeout passes or the request context is done.
+	PolicyBlock BackpressurePolicy = "block"
+	// PolicyReject rejects the request right away with ErrBufferFull.
+	PolicyReject BackpressurePolicy = "reject"
+	// PolicyDropOldest drops the oldest buffered request to make room for the new one.
+	PolicyDropOldest BackpressurePolicy = "drop_oldest"
+	// PolicyDropNewest drops the new request. The request is acknowledged anyway.
+	PolicyDropNewest BackpressurePolicy = "drop_newest"
+)
+
+// Backpressure applies a policy when pushing requests to a full buffer. The zero value blocks without timeout.
+type Backpressure struct {
+	Policy BackpressurePolicy
+	// Timeout bounds the wait of PolicyBlock, zero waits until the request context is done.
+	Timeout time.Duration
+}
+
+func (b Backpressure) push(ctx context.Context, ch chan CollectRequest, req CollectRequest) error {
+	select {
+	case ch <- req:
+		return nil
+	default:
+	}
+	tags := fmt.Sprintf("policy=%s,conn_group=%s", b.Policy, req.ConnectionIdentifier.Group)
+	switch b.Policy {
+	case PolicyReject:
+		metrics.Increment("batches_rejected_total", tags)
+		return ErrBufferFull
+	case PolicyDropNewest:
+		metrics.Increment("batches_dropped_total", tags)
+		return nil
+	case PolicyDropOldest:
+		for {
+			select {
+			case ch <- req:
+				return nil
+			default:
+			}
+			select {
+			case old := <-ch:
+				metrics.Increment("batches_dropped_total", fmt.Sprintf("policy=%s,conn_group=%s", b.Policy, old.ConnectionIdentifier.Group))
+			default:
+			}
+		}
+	}
+	var timeout <-chan time.Time
+	if b.Timeout > 0 {
+		timer := time.NewTimer(b.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ch <- req:
+		return nil
+	case <-timeout:
+		metrics.Increment("batches_rejected_total", tags)
+		return ErrBufferFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackpressure(t *testing.T) {
+	full := func() chan CollectRequest {
+		ch := make(chan CollectRequest, 1)
+		ch <- *requestOf("old")
+		return ch
+	}
+
+	t.Run("Should push without applying the policy when there is room", func(t *testing.T) {
+		ch := make(chan CollectRequest, 1)
+		assert.NoError(t, Backpressure{Policy: PolicyReject}.push(context.Background(), ch, *requestOf("new")))
+		assert.Len(t, ch, 1)
+	})
+
+	t.Run("Should reject when full", func(t *testing.T) {
+		ch := full()
+		assert.Equal(t, ErrBufferFull, Backpressure{Policy: PolicyReject}.push(context.Background(), ch, *requestOf("new")))
+		assert.Equal(t, "old", (<-ch).ConnectionIdentifier.Group)
+	})
+
+	t.Run("Should drop the new request when full", func(t *testing.T) {
+		ch := full()
+		assert.NoError(t, Backpressure{Policy: PolicyDropNewest}.push(context.Background(), ch, *requestOf("new")))
+		assert.Equal(t, "old", (<-ch).ConnectionIdentifier.Group)
+	})
+
+	t.Run("Should drop the oldest request when full", func(t *testing.T) {
+		ch := full()
+		assert.NoError(t, Backpressure{Policy: PolicyDropOldest}.push(context.Background(), ch, *requestOf("new")))
+		assert.Equal(t, "new", (<-ch).ConnectionIdentifier.Group)
+	})
+
+	t.Run("Should reject once the block timeout passes", func(t *testing.T) {
+		ch := full()
+		assert.Equal(t, ErrBufferFull, Backpressure{Policy: PolicyBlock, Timeout: 10 * time.Millisecond}.push(context.Background(), ch, *requestOf("new")))
+	})
+
+	t.Run("Should stop blocking when the context is done", func(t *testing.T) {
+		ch := full()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, Backpressure{}.push(ctx, ch, *requestOf("new")))
+	})
+
+	t.Run("Should push once room is made while blocking", func(t *testing.T) {
+		ch := full()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			<-ch
+		}()
+		assert.NoError(t, Backpressure{Policy: PolicyBlock, Timeout: time.Second}.push(context.Background(), ch, *requestOf("new")))
+		assert.Equal(t, "new", (<-ch).ConnectionIdentifier.Group)
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, Retryable(ErrBufferFull))
+	assert.True(t, Retryable(fmt.Errorf("%w: request with the same ReqGuid is being collected", ErrUnavailable)))
+	assert.False(t, Retryable(fmt.Errorf("%w: event type debug is not accepted", ErrInvalidRequest)))
+	assert.False(t, Retryable(context.Canceled))
+}
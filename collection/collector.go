@@ -2,6 +2,7 @@ package collection
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/odpf/raccoon/identification"
@@ -10,11 +11,101 @@ import (
 
 type CollectRequest struct {
 	ConnectionIdentifier identification.Identifier
-	TimeConsumed         time.Time
-	TimePushed           time.Time
+	// Client is the client which sent the request
+	Client       identification.Client
+	TimeConsumed time.Time
+	TimePushed   time.Time
+	// Enrichment holds the context added to the events of the request by the collector stages, published as the
+	// headers of their records
+	Enrichment map[string]string
+	// EventHeaders holds the context added to single events by the collector stages, published as the headers of
+	// their records along with the enrichment
+	EventHeaders map[*pb.Event]map[string]string
+	// ClockOffset is how far behind the server the clock of the client was found to be by the collector stages, zero
+	// when it is not known to be skewed. The events were sent at SentTime plus ClockOffset.
+	ClockOffset time.Duration
 	*pb.SendEventRequest
 }
 
+// SetEventHeader adds the header to the record of the event.
+func (r *CollectRequest) SetEventHeader(e *pb.Event, key, value string) {
+	if r.EventHeaders == nil {
+		r.EventHeaders = make(map[*pb.Event]map[string]string)
+	}
+	if r.EventHeaders[e] == nil {
+		r.EventHeaders[e] = make(map[string]string, 1)
+	}
+	r.EventHeaders[e][key] = value
+}
+
+// MoveEventHeaders carries the headers of the event from over to the event to, which replaces it.
+func (r *CollectRequest) MoveEventHeaders(from, to *pb.Event) {
+	headers, ok := r.EventHeaders[from]
+	if !ok {
+		return
+	}
+	delete(r.EventHeaders, from)
+	r.EventHeaders[to] = headers
+}
+
+// HeaderOriginalType is the header telling the type an event was sent with, once a collector stage published it with
+// another type.
+const HeaderOriginalType = "raccoon-original-type"
+
+// Retype returns the event published with eventType in place of e, its record carrying the headers of e and the type of
+// e in HeaderOriginalType. The caller replaces e with it in the events of the request.
+func (r *CollectRequest) Retype(e *pb.Event, eventType string) *pb.Event {
+	retyped := &pb.Event{EventBytes: e.EventBytes, Type: eventType}
+	r.MoveEventHeaders(e, retyped)
+	if _, ok := r.Headers(retyped)[HeaderOriginalType]; !ok {
+		r.SetEventHeader(retyped, HeaderOriginalType, e.Type)
+	}
+	return retyped
+}
+
+// Headers returns the headers of the record of the event: the enrichment, and the headers of the event over it.
+func (r *CollectRequest) Headers(e *pb.Event) map[string]string {
+	headers, ok := r.EventHeaders[e]
+	if !ok {
+		return r.Enrichment
+	}
+	merged := make(map[string]string, len(r.Enrichment)+len(headers))
+	for key, value := range r.Enrichment {
+		merged[key] = value
+	}
+	for key, value := range headers {
+		merged[key] = value
+	}
+	return merged
+}
+
+// ErrInvalidRequest is wrapped by the errors of requests which are not accepted. Retrying them would fail the same.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrUnavailable is wrapped by the errors of requests which cannot be collected for now. Retrying them later may
+// succeed, as for ErrBufferFull.
+var ErrUnavailable = errors.New("unavailable")
+
+// Retryable tells whether the request which failed with err can be retried later.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrBufferFull) || errors.Is(err, ErrUnavailable)
+}
+
+// FailureData returns the data of the response to the request which failed with err: its ReqGuid, retryable when it
+// can be retried, as no response code tells it, and collected_events the indexes of its events which were collected
+// anyway, if any.
+func FailureData(reqGuid string, err error) map[string]string {
+	data := map[string]string{"req_guid": reqGuid}
+	if Retryable(err) {
+		data["retryable"] = "true"
+	}
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		data["collected_events"] = joinIndexes(partial.Collected)
+	}
+	return data
+}
+
 type Collector interface {
 	Collect(ctx context.Context, req *CollectRequest) error
 }
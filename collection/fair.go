@@ -0,0 +1,188 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/odpf/raccoon/metrics"
+)
+
+// FairQueue is a Collector keeping a bounded queue per connection group. Batches are moved from the group queues
+// to the workers' channel by weighted round robin so that a noisy group only fills its own queue.
+type FairQueue struct {
+	out           chan<- CollectRequest
+	queueSize     int
+	weights       map[string]int
+	defaultWeight int
+	backpressure  Backpressure
+
+	m      sync.RWMutex
+	queues []*groupQueue
+	groups map[string]*groupQueue
+	notify chan struct{}
+	// held is the batch taken from its group queue when closed, before it could be moved to the workers
+	held   []CollectRequest
+	done   chan struct{}
+	closed chan struct{}
+}
+
+type groupQueue struct {
+	group  string
+	weight int
+	ch     chan CollectRequest
+}
+
+// NewFairQueue creates a fair queue feeding out. Groups missing from weights get defaultWeight.
+// The backpressure policy applies to the queue of each group.
+func NewFairQueue(out chan<- CollectRequest, queueSize int, weights map[string]int, defaultWeight int, backpressure Backpressure) *FairQueue {
+	return &FairQueue{
+		out:           out,
+		queueSize:     queueSize,
+		weights:       weights,
+		defaultWeight: defaultWeight,
+		backpressure:  backpressure,
+		groups:        make(map[string]*groupQueue),
+		notify:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Collect enqueues the request in the queue of its group, applying the backpressure policy while that queue is full.
+// Requests are no longer accepted once closed.
+func (f *FairQueue) Collect(ctx context.Context, req *CollectRequest) error {
+	select {
+	case <-f.done:
+		return fmt.Errorf("%w: shutting down", ErrUnavailable)
+	default:
+	}
+	q := f.queue(req.ConnectionIdentifier.Group)
+	req.TimePushed = time.Now()
+	if err := f.backpressure.push(ctx, q.ch, *req); err != nil {
+		return err
+	}
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (f *FairQueue) queue(group string) *groupQueue {
+	f.m.RLock()
+	q, ok := f.groups[group]
+	f.m.RUnlock()
+	if ok {
+		return q
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if q, ok := f.groups[group]; ok {
+		return q
+	}
+	weight, ok := f.weights[group]
+	if !ok {
+		weight = f.defaultWeight
+	}
+	q = &groupQueue{group: group, weight: weight, ch: make(chan CollectRequest, f.queueSize)}
+	f.groups[group] = q
+	f.queues = append(f.queues, q)
+	return q
+}
+
+func (f *FairQueue) snapshot() []*groupQueue {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	return f.queues
+}
+
+// Run moves the queued batches to the workers until Close is called. Each round takes up to weight batches per group.
+func (f *FairQueue) Run() {
+	defer close(f.closed)
+	for {
+		moved := false
+		for _, q := range f.snapshot() {
+		group:
+			for i := 0; i < q.weight; i++ {
+				select {
+				case req := <-q.ch:
+					select {
+					case f.out <- req:
+						moved = true
+					case <-f.done:
+						f.held = append(f.held, req)
+						return
+					}
+				default:
+					break group
+				}
+			}
+		}
+		if !moved {
+			select {
+			case <-f.notify:
+			case <-f.done:
+				return
+			}
+		}
+	}
+}
+
+// ReportStats reports the depth of every group queue on each interval until Close is called.
+func (f *FairQueue) ReportStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, q := range f.snapshot() {
+				metrics.Gauge("group_queue_batches_current", len(q.ch), "conn_group="+q.group)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// Pending returns the number of batches left in the group queues.
+func (f *FairQueue) Pending() int {
+	pending := len(f.held)
+	for _, q := range f.snapshot() {
+		pending += len(q.ch)
+	}
+	return pending
+}
+
+// Drain removes and returns the batches left in the group queues. Must be called once closed.
+func (f *FairQueue) Drain() []CollectRequest {
+	requests := f.held
+	f.held = nil
+	for _, q := range f.snapshot() {
+		requests = append(requests, DrainChannel(q.ch)...)
+	}
+	return requests
+}
+
+// Flush closes the queue, then moves every batch left in the group queues to the workers, group by group, waiting
+// for room until timeout passes. Returns the batches which could not be moved.
+func (f *FairQueue) Flush(timeout time.Duration) []CollectRequest {
+	f.Close()
+	left := f.Drain()
+	deadline := time.After(timeout)
+	for i, req := range left {
+		select {
+		case f.out <- req:
+		case <-deadline:
+			return left[i:]
+		}
+	}
+	return nil
+}
+
+// Close stops accepting requests and moving batches to the workers. Batches still queued stay pending.
+func (f *FairQueue) Close() {
+	close(f.done)
+	<-f.closed
+}
@@ -0,0 +1,93 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/identification"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestOf(group string) *CollectRequest {
+	return &CollectRequest{ConnectionIdentifier: identification.Identifier{Group: group}}
+}
+
+func TestFairQueue(t *testing.T) {
+	t.Run("Should take batches from each group according to its weight", func(t *testing.T) {
+		out := make(chan CollectRequest, 100)
+		f := NewFairQueue(out, 10, map[string]int{"viewer": 2}, 1, Backpressure{})
+		for i := 0; i < 6; i++ {
+			assert.NoError(t, f.Collect(context.Background(), requestOf("viewer")))
+		}
+		for i := 0; i < 6; i++ {
+			assert.NoError(t, f.Collect(context.Background(), requestOf("driver")))
+		}
+		go f.Run()
+		defer f.Close()
+
+		var groups []string
+		for i := 0; i < 6; i++ {
+			groups = append(groups, (<-out).ConnectionIdentifier.Group)
+		}
+		assert.Equal(t, []string{"viewer", "viewer", "driver", "viewer", "viewer", "driver"}, groups)
+	})
+
+	t.Run("Should only block the group whose queue is full", func(t *testing.T) {
+		f := NewFairQueue(make(chan CollectRequest), 1, nil, 1, Backpressure{})
+		assert.NoError(t, f.Collect(context.Background(), requestOf("viewer")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, f.Collect(ctx, requestOf("viewer")))
+		assert.NoError(t, f.Collect(context.Background(), requestOf("driver")))
+		assert.Equal(t, 2, f.Pending())
+	})
+
+	t.Run("Should keep queued and held batches pending once closed", func(t *testing.T) {
+		out := make(chan CollectRequest)
+		f := NewFairQueue(out, 10, nil, 1, Backpressure{})
+		go f.Run()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, f.Collect(context.Background(), requestOf("viewer")))
+		}
+		assert.Eventually(t, func() bool { return f.Pending() == 2 }, time.Second, time.Millisecond)
+		f.Close()
+		assert.Equal(t, 3, f.Pending())
+		assert.Len(t, f.Drain(), 3)
+		assert.Equal(t, 0, f.Pending())
+		assert.True(t, errors.Is(f.Collect(context.Background(), requestOf("viewer")), ErrUnavailable))
+	})
+
+	t.Run("Should move every queued batch when flushing", func(t *testing.T) {
+		out := make(chan CollectRequest, 1)
+		f := NewFairQueue(out, 10, map[string]int{"viewer": 5}, 1, Backpressure{})
+		go f.Run()
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, f.Collect(context.Background(), requestOf("viewer")))
+			assert.NoError(t, f.Collect(context.Background(), requestOf("driver")))
+		}
+		var groups []string
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+			for req := range out {
+				groups = append(groups, req.ConnectionIdentifier.Group)
+			}
+		}()
+		assert.Empty(t, f.Flush(time.Second))
+		close(out)
+		<-read
+		assert.ElementsMatch(t, []string{"viewer", "viewer", "viewer", "viewer", "driver", "driver", "driver", "driver"}, groups)
+	})
+
+	t.Run("Should return the batches which could not be moved within the timeout", func(t *testing.T) {
+		f := NewFairQueue(make(chan CollectRequest), 10, nil, 1, Backpressure{})
+		go f.Run()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, f.Collect(context.Background(), requestOf("driver")))
+		}
+		assert.Len(t, f.Flush(10*time.Millisecond), 3)
+	})
+}
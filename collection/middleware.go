@@ -0,0 +1,49 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/odpf/raccoon/metrics"
+)
+
+// Middleware decorates a Collector to act on every request, whatever the protocol it was received with.
+type Middleware func(Collector) Collector
+
+// CollectorFunc adapts a function to a Collector.
+type CollectorFunc func(ctx context.Context, req *CollectRequest) error
+
+func (f CollectorFunc) Collect(ctx context.Context, req *CollectRequest) error {
+	return f(ctx, req)
+}
+
+// Chain returns c decorated by the middlewares. The first middleware is the first to act on a request.
+func Chain(c Collector, middlewares ...Middleware) Collector {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		c = middlewares[i](c)
+	}
+	return c
+}
+
+// CountEvents reports the events received per connection group and event type.
+func CountEvents(next Collector) Collector {
+	return CollectorFunc(func(ctx context.Context, req *CollectRequest) error {
+		for _, e := range req.GetEvents() {
+			tags := fmt.Sprintf("conn_group=%s,event_type=%s", req.ConnectionIdentifier.Group, e.Type)
+			metrics.Count("events_rx_bytes_total", len(e.EventBytes), tags)
+			metrics.Increment("events_rx_total", tags)
+		}
+		return next.Collect(ctx, req)
+	})
+}
+
+// SkipEmpty acknowledges the requests without events without collecting them.
+func SkipEmpty(next Collector) Collector {
+	return CollectorFunc(func(ctx context.Context, req *CollectRequest) error {
+		if len(req.GetEvents()) == 0 {
+			metrics.Increment("batches_skipped_total", fmt.Sprintf("reason=empty,conn_group=%s", req.ConnectionIdentifier.Group))
+			return nil
+		}
+		return next.Collect(ctx, req)
+	})
+}
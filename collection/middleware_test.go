@@ -0,0 +1,48 @@
+package collection
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Should apply the middlewares in order", func(t *testing.T) {
+		var order []string
+		stage := func(name string) Middleware {
+			return func(next Collector) Collector {
+				return CollectorFunc(func(ctx context.Context, req *CollectRequest) error {
+					order = append(order, name)
+					return next.Collect(ctx, req)
+				})
+			}
+		}
+		c := &MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+
+		assert.NoError(t, Chain(c, stage("first"), stage("second")).Collect(ctx, requestOfTypes("click")))
+		assert.Equal(t, []string{"first", "second"}, order)
+		c.AssertNumberOfCalls(t, "Collect", 1)
+	})
+
+	t.Run("Should return the collector without middleware", func(t *testing.T) {
+		c := &MockCollector{}
+		assert.Equal(t, Collector(c), Chain(c))
+	})
+}
+
+func TestSkipEmpty(t *testing.T) {
+	ctx := context.Background()
+	c := &MockCollector{}
+	c.On("Collect", ctx, mock.Anything).Return(nil)
+	skip := SkipEmpty(c)
+
+	assert.NoError(t, skip.Collect(ctx, requestOfTypes()))
+	c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	assert.NoError(t, skip.Collect(ctx, requestOfTypes("click")))
+	c.AssertNumberOfCalls(t, "Collect", 1)
+}
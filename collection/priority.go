@@ -0,0 +1,94 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/odpf/raccoon/proto"
+)
+
+// PartialError is returned when only some events of a request were collected. Resending the whole request would
+// collect them again, clients should only resend the other events.
+type PartialError struct {
+	// Collected are the indexes of the collected events in the request
+	Collected []int
+	Err       error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("events %s collected, the others failed: %v", joinIndexes(e.Collected), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+func joinIndexes(indexes []int) string {
+	s := make([]string, len(indexes))
+	for i, index := range indexes {
+		s[i] = strconv.Itoa(index)
+	}
+	return strings.Join(s, ",")
+}
+
+// PriorityCollector is a Collector routing the events of the priority types to their own collector, so that they do
+// not queue behind the bulk traffic. A batch mixing both is split in two batches of the same connection.
+type PriorityCollector struct {
+	types    map[string]struct{}
+	priority Collector
+	bulk     Collector
+}
+
+// NewPriorityCollector creates a collector sending the events of types to priority and every other event to bulk.
+func NewPriorityCollector(types []string, priority, bulk Collector) *PriorityCollector {
+	set := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		set[t] = struct{}{}
+	}
+	return &PriorityCollector{
+		types:    set,
+		priority: priority,
+		bulk:     bulk,
+	}
+}
+
+// Collect collects the priority events first. When the bulk events cannot be collected afterwards, a PartialError
+// tells which events were collected.
+func (p *PriorityCollector) Collect(ctx context.Context, req *CollectRequest) error {
+	var priority, bulk []*pb.Event
+	var collected []int
+	for i, e := range req.GetEvents() {
+		if _, ok := p.types[e.Type]; ok {
+			priority = append(priority, e)
+			collected = append(collected, i)
+		} else {
+			bulk = append(bulk, e)
+		}
+	}
+	switch {
+	case len(priority) == 0:
+		return p.bulk.Collect(ctx, req)
+	case len(bulk) == 0:
+		return p.priority.Collect(ctx, req)
+	}
+	if err := p.priority.Collect(ctx, withEvents(req, priority)); err != nil {
+		return err
+	}
+	if err := p.bulk.Collect(ctx, withEvents(req, bulk)); err != nil {
+		return &PartialError{Collected: collected, Err: err}
+	}
+	return nil
+}
+
+// withEvents returns a copy of the request holding events.
+func withEvents(req *CollectRequest, events []*pb.Event) *CollectRequest {
+	r := *req
+	r.SendEventRequest = &pb.SendEventRequest{
+		ReqGuid:  req.ReqGuid,
+		SentTime: req.SentTime,
+		Events:   events,
+	}
+	return &r
+}
@@ -0,0 +1,97 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func requestOfTypes(types ...string) *CollectRequest {
+	req := &CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "12345", Group: "viewer"},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abcd", SentTime: timestamppb.Now()},
+	}
+	for _, t := range types {
+		req.Events = append(req.Events, &pb.Event{Type: t})
+	}
+	return req
+}
+
+func typesOf(req *CollectRequest) []string {
+	var types []string
+	for _, e := range req.Events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestPriorityCollector(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Should route each batch to its lane", func(t *testing.T) {
+		priority, bulk := make(chan CollectRequest, 10), make(chan CollectRequest, 10)
+		c := NewPriorityCollector([]string{"payment", "login"}, NewChannelCollector(priority), NewChannelCollector(bulk))
+
+		assert.NoError(t, c.Collect(ctx, requestOfTypes("payment", "login")))
+		assert.NoError(t, c.Collect(ctx, requestOfTypes("click")))
+		assert.NoError(t, c.Collect(ctx, requestOfTypes("click", "payment", "scroll")))
+
+		p := DrainChannel(priority)
+		assert.Len(t, p, 2)
+		assert.Equal(t, []string{"payment", "login"}, typesOf(&p[0]))
+		assert.Equal(t, []string{"payment"}, typesOf(&p[1]))
+		assert.Equal(t, "abcd", p[1].ReqGuid)
+		assert.Equal(t, "12345", p[1].ConnectionIdentifier.ID)
+
+		b := DrainChannel(bulk)
+		assert.Len(t, b, 2)
+		assert.Equal(t, []string{"click"}, typesOf(&b[0]))
+		assert.Equal(t, []string{"click", "scroll"}, typesOf(&b[1]))
+	})
+
+	t.Run("Should keep the context of a split batch", func(t *testing.T) {
+		priority, bulk := make(chan CollectRequest, 10), make(chan CollectRequest, 10)
+		c := NewPriorityCollector([]string{"payment"}, NewChannelCollector(priority), NewChannelCollector(bulk))
+		req := requestOfTypes("click", "payment")
+		req.Client = identification.Client{RemoteAddr: "10.0.0.1:5000", UserAgent: "curl/7.68.0"}
+		req.Enrichment = map[string]string{"raccoon-client-ip": "10.0.0.1"}
+
+		assert.NoError(t, c.Collect(ctx, req))
+		for _, split := range append(DrainChannel(priority), DrainChannel(bulk)...) {
+			assert.Equal(t, req.Client, split.Client)
+			assert.Equal(t, req.Enrichment, split.Enrichment)
+			assert.Equal(t, req.SentTime, split.SentTime)
+		}
+	})
+
+	t.Run("Should not collect the bulk events when the priority ones are rejected", func(t *testing.T) {
+		priority, bulk := &MockCollector{}, &MockCollector{}
+		priority.On("Collect", ctx, mock.Anything).Return(ErrBufferFull)
+		c := NewPriorityCollector([]string{"payment"}, priority, bulk)
+
+		assert.Equal(t, ErrBufferFull, c.Collect(ctx, requestOfTypes("click", "payment")))
+		bulk.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should tell the priority events collected when the bulk ones are rejected", func(t *testing.T) {
+		priority, bulk := &MockCollector{}, &MockCollector{}
+		priority.On("Collect", ctx, mock.Anything).Return(nil)
+		bulk.On("Collect", ctx, mock.Anything).Return(ErrBufferFull)
+		c := NewPriorityCollector([]string{"payment", "login"}, priority, bulk)
+
+		err := c.Collect(ctx, requestOfTypes("login", "click", "payment"))
+		var partial *PartialError
+		require.True(t, errors.As(err, &partial))
+		assert.Equal(t, []int{0, 2}, partial.Collected)
+		assert.True(t, errors.Is(err, ErrBufferFull))
+		assert.Equal(t, "events 0,2 collected, the others failed: buffer is full", err.Error())
+		assert.Equal(t, map[string]string{"req_guid": "abcd", "retryable": "true", "collected_events": "0,2"}, FailureData("abcd", err))
+	})
+}
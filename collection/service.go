@@ -6,7 +6,8 @@ import (
 )
 
 type ChannelCollector struct {
-	ch chan CollectRequest
+	ch           chan CollectRequest
+	backpressure Backpressure
 }
 
 func NewChannelCollector(c chan CollectRequest) Collector {
@@ -15,8 +16,31 @@ func NewChannelCollector(c chan CollectRequest) Collector {
 	}
 }
 
+// NewChannelCollectorWithBackpressure creates a collector applying the backpressure policy when the channel is full.
+func NewChannelCollectorWithBackpressure(c chan CollectRequest, backpressure Backpressure) Collector {
+	return &ChannelCollector{
+		ch:           c,
+		backpressure: backpressure,
+	}
+}
+
 func (c *ChannelCollector) Collect(ctx context.Context, req *CollectRequest) error {
 	req.TimePushed = time.Now()
-	c.ch <- *req
-	return nil
+	return c.backpressure.push(ctx, c.ch, *req)
+}
+
+// DrainChannel removes and returns the requests buffered in the channel without waiting for new ones.
+func DrainChannel(c <-chan CollectRequest) []CollectRequest {
+	var requests []CollectRequest
+	for {
+		select {
+		case req, ok := <-c:
+			if !ok {
+				return requests
+			}
+			requests = append(requests, req)
+		default:
+			return requests
+		}
+	}
 }
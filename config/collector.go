@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/odpf/raccoon/config/util"
+	"github.com/spf13/viper"
+)
+
+var Collector collector
+
+type collector struct {
+	// Middlewares are the stages every request goes through before being buffered, in order
+	Middlewares []string
+	// DedupTTL is the window within which a ReqGuid is deduplicated
+	DedupTTL time.Duration
+	// DedupRedisAddr is the redis server deduplicating across the fleet. Empty deduplicates in memory.
+	DedupRedisAddr     string
+	DedupRedisPassword string
+	DedupRedisPoolSize int
+	// DedupRedisTimeout bounds every command sent to redis
+	DedupRedisTimeout time.Duration
+	// FilterAllow and FilterDeny are the event types accepted and not accepted from each connection group, "*" being
+	// every group
+	FilterAllow map[string][]string
+	FilterDeny  map[string][]string
+	// FilterAction is what to do with the events not accepted, one of drop, reject and quarantine
+	FilterAction string
+	// FilterQuarantineType is the type the quarantined events are published with
+	FilterQuarantineType string
+	// SampleRates are the fractions of events kept by sampling, keyed by group then type, "*" being every group or type
+	SampleRates map[string]map[string]float64
+	// SchemaDescriptorFile is the FileDescriptorSet holding the messages of SchemaMessages
+	SchemaDescriptorFile string
+	// SchemaMessages maps the event types to the full name of the protobuf message of their bytes
+	SchemaMessages map[string]string
+	// JSONEventTypes are the event types sent as the JSON of their message, converted to protobuf by the json stage
+	JSONEventTypes []string
+	// ValidationRequireFields enforces the required fields of proto2 messages
+	ValidationRequireFields bool
+	// ValidationAction is what to do with the invalid events, one of drop, reject and quarantine
+	ValidationAction string
+	// ValidationQuarantineType is the type the invalid events are published with when quarantined
+	ValidationQuarantineType string
+	// RedactFields maps the event types to the path of their fields to the action redacting them, one of drop, mask
+	// and hash
+	RedactFields map[string]map[string]string
+	// RedactKeyFile holds the key the hashed fields are hashed with
+	RedactKeyFile string
+	// RedactKeyReload is how often the key file is read again
+	RedactKeyReload time.Duration
+	// EnrichTrustedProxies are the addresses and CIDR ranges of the proxies whose X-Forwarded-For header is trusted
+	EnrichTrustedProxies []string
+	// EnrichGeoDatabase is the MaxMind database the location of the clients is looked up in, empty to not look it up
+	EnrichGeoDatabase string
+	// ClockSkewThreshold is the offset of the clock of a client beyond which it is taken as skewed
+	ClockSkewThreshold time.Duration
+	// TimelinessMaxAge is how long ago a batch may have been sent, zero for no limit
+	TimelinessMaxAge time.Duration
+	// TimelinessStaleAction is what to do with the batches sent longer ago, one of flag, reroute and reject
+	TimelinessStaleAction string
+	// TimelinessMaxAhead is how far in the future a batch may have been sent, zero for no limit
+	TimelinessMaxAhead time.Duration
+	// TimelinessFutureAction is what to do with the batches sent further in the future, one of flag, reroute and reject
+	TimelinessFutureAction string
+	// TimelinessRerouteType is the type the events of rerouted batches are published with
+	TimelinessRerouteType string
+}
+
+func collectorConfigLoader() {
+	viper.SetDefault("COLLECTOR_MIDDLEWARES", "")
+	viper.SetDefault("COLLECTOR_DEDUP_TTL_MS", 60000)
+	viper.SetDefault("COLLECTOR_DEDUP_REDIS_ADDR", "")
+	viper.SetDefault("COLLECTOR_DEDUP_REDIS_PASSWORD", "")
+	viper.SetDefault("COLLECTOR_DEDUP_REDIS_POOL_SIZE", 10)
+	viper.SetDefault("COLLECTOR_DEDUP_REDIS_TIMEOUT_MS", 100)
+	viper.SetDefault("COLLECTOR_FILTER_ALLOW", "")
+	viper.SetDefault("COLLECTOR_FILTER_DENY", "")
+	viper.SetDefault("COLLECTOR_FILTER_ACTION", "drop")
+	viper.SetDefault("COLLECTOR_FILTER_QUARANTINE_TYPE", "quarantine")
+	viper.SetDefault("COLLECTOR_SAMPLE_RATES", "")
+	viper.SetDefault("COLLECTOR_SCHEMA_DESCRIPTOR_FILE", "")
+	viper.SetDefault("COLLECTOR_SCHEMA_MESSAGES", "")
+	viper.SetDefault("COLLECTOR_JSON_EVENT_TYPES", "")
+	viper.SetDefault("COLLECTOR_VALIDATION_REQUIRE_FIELDS", false)
+	viper.SetDefault("COLLECTOR_VALIDATION_ACTION", "reject")
+	viper.SetDefault("COLLECTOR_VALIDATION_QUARANTINE_TYPE", "quarantine")
+	viper.SetDefault("COLLECTOR_REDACT_FIELDS", "")
+	viper.SetDefault("COLLECTOR_REDACT_KEY_FILE", "")
+	viper.SetDefault("COLLECTOR_REDACT_KEY_RELOAD_MS", 60000)
+	viper.SetDefault("COLLECTOR_ENRICH_TRUSTED_PROXIES", "")
+	viper.SetDefault("COLLECTOR_ENRICH_GEO_DATABASE", "")
+	viper.SetDefault("COLLECTOR_CLOCK_SKEW_THRESHOLD_MS", 60000)
+	viper.SetDefault("COLLECTOR_TIMELINESS_MAX_AGE_MS", 0)
+	viper.SetDefault("COLLECTOR_TIMELINESS_STALE_ACTION", "flag")
+	viper.SetDefault("COLLECTOR_TIMELINESS_MAX_AHEAD_MS", 0)
+	viper.SetDefault("COLLECTOR_TIMELINESS_FUTURE_ACTION", "flag")
+	viper.SetDefault("COLLECTOR_TIMELINESS_REROUTE_TYPE", "late")
+	Collector = collector{
+		Middlewares:        util.MustGetStringSlice("COLLECTOR_MIDDLEWARES"),
+		DedupTTL:           util.MustGetDuration("COLLECTOR_DEDUP_TTL_MS", time.Millisecond),
+		DedupRedisAddr:     util.MustGetString("COLLECTOR_DEDUP_REDIS_ADDR"),
+		DedupRedisPassword: util.MustGetString("COLLECTOR_DEDUP_REDIS_PASSWORD"),
+		DedupRedisPoolSize: util.MustGetInt("COLLECTOR_DEDUP_REDIS_POOL_SIZE"),
+		DedupRedisTimeout:  util.MustGetDuration("COLLECTOR_DEDUP_REDIS_TIMEOUT_MS", time.Millisecond),
+
+		FilterAllow:          groupTypesConfigLoader("COLLECTOR_FILTER_ALLOW"),
+		FilterDeny:           groupTypesConfigLoader("COLLECTOR_FILTER_DENY"),
+		FilterAction:         util.MustGetString("COLLECTOR_FILTER_ACTION"),
+		FilterQuarantineType: util.MustGetString("COLLECTOR_FILTER_QUARANTINE_TYPE"),
+
+		SampleRates: sampleRatesConfigLoader(),
+
+		SchemaDescriptorFile:     util.MustGetString("COLLECTOR_SCHEMA_DESCRIPTOR_FILE"),
+		SchemaMessages:           schemaMessagesConfigLoader(),
+		JSONEventTypes:           util.MustGetStringSlice("COLLECTOR_JSON_EVENT_TYPES"),
+		ValidationRequireFields:  util.MustGetBool("COLLECTOR_VALIDATION_REQUIRE_FIELDS"),
+		ValidationAction:         util.MustGetString("COLLECTOR_VALIDATION_ACTION"),
+		ValidationQuarantineType: util.MustGetString("COLLECTOR_VALIDATION_QUARANTINE_TYPE"),
+
+		RedactFields:    redactFieldsConfigLoader(),
+		RedactKeyFile:   util.MustGetString("COLLECTOR_REDACT_KEY_FILE"),
+		RedactKeyReload: util.MustGetDuration("COLLECTOR_REDACT_KEY_RELOAD_MS", time.Millisecond),
+
+		EnrichTrustedProxies: util.MustGetStringSlice("COLLECTOR_ENRICH_TRUSTED_PROXIES"),
+		EnrichGeoDatabase:    util.MustGetString("COLLECTOR_ENRICH_GEO_DATABASE"),
+
+		ClockSkewThreshold: util.MustGetDuration("COLLECTOR_CLOCK_SKEW_THRESHOLD_MS", time.Millisecond),
+
+		TimelinessMaxAge:       util.MustGetDuration("COLLECTOR_TIMELINESS_MAX_AGE_MS", time.Millisecond),
+		TimelinessStaleAction:  util.MustGetString("COLLECTOR_TIMELINESS_STALE_ACTION"),
+		TimelinessMaxAhead:     util.MustGetDuration("COLLECTOR_TIMELINESS_MAX_AHEAD_MS", time.Millisecond),
+		TimelinessFutureAction: util.MustGetString("COLLECTOR_TIMELINESS_FUTURE_ACTION"),
+		TimelinessRerouteType:  util.MustGetString("COLLECTOR_TIMELINESS_REROUTE_TYPE"),
+	}
+	stages := make(map[string]int, len(Collector.Middlewares))
+	for i, name := range Collector.Middlewares {
+		stages[name] = i
+	}
+	if j, ok := stages["json"]; ok {
+		// the stages after json read the events of COLLECTOR_JSON_EVENT_TYPES as protobuf messages, redact would let
+		// their fields through in clear
+		for _, name := range []string{"validate", "redact"} {
+			if i, ok := stages[name]; ok && i < j {
+				panic(fmt.Sprintf("key COLLECTOR_MIDDLEWARES should list json before %s", name))
+			}
+		}
+	}
+	if Collector.DedupTTL <= 0 {
+		panic("key COLLECTOR_DEDUP_TTL_MS should be positive")
+	}
+	if Collector.RedactKeyReload <= 0 {
+		panic("key COLLECTOR_REDACT_KEY_RELOAD_MS should be positive")
+	}
+	if Collector.ClockSkewThreshold <= 0 {
+		panic("key COLLECTOR_CLOCK_SKEW_THRESHOLD_MS should be positive")
+	}
+	if Collector.TimelinessMaxAge < 0 || Collector.TimelinessMaxAhead < 0 {
+		panic("keys COLLECTOR_TIMELINESS_MAX_AGE_MS and COLLECTOR_TIMELINESS_MAX_AHEAD_MS should not be negative")
+	}
+	for key, action := range map[string]string{"COLLECTOR_FILTER_ACTION": Collector.FilterAction, "COLLECTOR_VALIDATION_ACTION": Collector.ValidationAction} {
+		switch action {
+		case "drop", "reject", "quarantine":
+		default:
+			panic(fmt.Sprintf("key %s should be one of drop, reject and quarantine, got %s", key, action))
+		}
+	}
+	for key, action := range map[string]string{"COLLECTOR_TIMELINESS_STALE_ACTION": Collector.TimelinessStaleAction, "COLLECTOR_TIMELINESS_FUTURE_ACTION": Collector.TimelinessFutureAction} {
+		switch action {
+		case "flag", "reroute", "reject":
+		default:
+			panic(fmt.Sprintf("key %s should be one of flag, reroute and reject, got %s", key, action))
+		}
+	}
+}
+
+// groupTypesConfigLoader parses key, a comma separated list of group:type|type
+func groupTypesConfigLoader(key string) map[string][]string {
+	groupTypes := make(map[string][]string)
+	for _, rule := range util.MustGetStringSlice(key) {
+		i := strings.LastIndex(rule, ":")
+		if i < 1 {
+			panic(fmt.Sprintf("key %s should be a list of group:type|type, got %s", key, rule))
+		}
+		group := strings.TrimSpace(rule[:i])
+		for _, t := range strings.Split(rule[i+1:], "|") {
+			if t = strings.TrimSpace(t); t != "" {
+				groupTypes[group] = append(groupTypes[group], t)
+			}
+		}
+	}
+	return groupTypes
+}
+
+// sampleRatesConfigLoader parses COLLECTOR_SAMPLE_RATES, a comma separated list of group:type:rate
+func sampleRatesConfigLoader() map[string]map[string]float64 {
+	rates := make(map[string]map[string]float64)
+	for _, rule := range util.MustGetStringSlice("COLLECTOR_SAMPLE_RATES") {
+		parts := strings.Split(rule, ":")
+		if len(parts) != 3 {
+			panic(fmt.Sprintf("key COLLECTOR_SAMPLE_RATES should be a list of group:type:rate, got %s", rule))
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			panic(fmt.Sprintf("key COLLECTOR_SAMPLE_RATES should have rates between 0 and 1, got %s", rule))
+		}
+		group, eventType := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if rates[group] == nil {
+			rates[group] = make(map[string]float64)
+		}
+		rates[group][eventType] = rate
+	}
+	return rates
+}
+
+// schemaMessagesConfigLoader parses COLLECTOR_SCHEMA_MESSAGES, a comma separated list of type:message
+func schemaMessagesConfigLoader() map[string]string {
+	messages := make(map[string]string)
+	for _, rule := range util.MustGetStringSlice("COLLECTOR_SCHEMA_MESSAGES") {
+		parts := strings.Split(rule, ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			panic(fmt.Sprintf("key COLLECTOR_SCHEMA_MESSAGES should be a list of type:message, got %s", rule))
+		}
+		messages[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return messages
+}
+
+// redactFieldsConfigLoader parses COLLECTOR_REDACT_FIELDS, a comma separated list of type:path:action
+func redactFieldsConfigLoader() map[string]map[string]string {
+	fields := make(map[string]map[string]string)
+	for _, rule := range util.MustGetStringSlice("COLLECTOR_REDACT_FIELDS") {
+		parts := strings.Split(rule, ":")
+		if len(parts) != 3 {
+			panic(fmt.Sprintf("key COLLECTOR_REDACT_FIELDS should be a list of type:path:action, got %s", rule))
+		}
+		eventType, path, action := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+		switch action {
+		case "drop", "mask", "hash":
+		default:
+			panic(fmt.Sprintf("key COLLECTOR_REDACT_FIELDS should have actions among drop, mask and hash, got %s", rule))
+		}
+		if fields[eventType] == nil {
+			fields[eventType] = make(map[string]string)
+		}
+		fields[eventType][path] = action
+	}
+	return fields
+}
@@ -24,7 +24,9 @@ func Load() {
 	publisherKafkaConfigLoader()
 	serverWsConfigLoader()
 	serverGRPCConfigLoader()
+	serverAdminConfigLoader()
 	workerConfigLoader()
+	collectorConfigLoader()
 	metricStatsdConfigLoader()
 	eventDistributionConfigLoader()
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -32,6 +33,7 @@ func TestServerConfig(t *testing.T) {
 	assert.Equal(t, "8080", ServerWs.AppPort)
 	assert.Equal(t, time.Duration(1)*time.Millisecond, ServerWs.PingInterval)
 	assert.Equal(t, time.Duration(1)*time.Millisecond, ServerWs.PongWaitInterval)
+	assert.False(t, ServerWs.TimeEndpoint)
 }
 
 func TestGRPCServerConfig(t *testing.T) {
@@ -40,6 +42,16 @@ func TestGRPCServerConfig(t *testing.T) {
 	assert.Equal(t, "8081", ServerGRPC.Port)
 }
 
+func TestAdminServerConfig(t *testing.T) {
+	serverAdminConfigLoader()
+	assert.Equal(t, "", ServerAdmin.Addr)
+
+	os.Setenv("SERVER_ADMIN_ADDR", "localhost:8082")
+	defer os.Unsetenv("SERVER_ADMIN_ADDR")
+	serverAdminConfigLoader()
+	assert.Equal(t, "localhost:8082", ServerAdmin.Addr)
+}
+
 func TestDynamicConfigLoad(t *testing.T) {
 	os.Setenv("PUBLISHER_KAFKA_CLIENT_RANDOM", "anything")
 	os.Setenv("PUBLISHER_KAFKA_CLIENT_BOOTSTRAP_SERVERS", "localhost:9092")
@@ -64,6 +76,63 @@ func TestKafkaConfig_ToKafkaConfigMap(t *testing.T) {
 	assert.Equal(t, "", topic)
 	assert.NotEqual(t, something, "anything")
 	assert.Equal(t, 4, len(*kafkaConfig))
+	assert.Equal(t, 0, PublisherKafka.MockBrokers)
+	assert.Equal(t, 1, PublisherKafka.ProducerPoolSize)
+	assert.Empty(t, PublisherKafka.AggregationEventTypes)
+	assert.Equal(t, 500*time.Millisecond, PublisherKafka.AggregationLinger)
+	assert.Empty(t, PublisherKafka.JSONEventTypes)
+
+	os.Setenv("PUBLISHER_KAFKA_JSON_EVENT_TYPES", "click,scroll")
+	defer os.Unsetenv("PUBLISHER_KAFKA_JSON_EVENT_TYPES")
+	publisherKafkaConfigLoader()
+	assert.Equal(t, []string{"click", "scroll"}, PublisherKafka.JSONEventTypes)
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_EVENT_TYPES", "scroll")
+	defer os.Unsetenv("PUBLISHER_KAFKA_AGGREGATION_EVENT_TYPES")
+	assert.Panics(t, func() { publisherKafkaConfigLoader() })
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_EVENT_TYPES", "")
+
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS", "0")
+	defer os.Unsetenv("PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS")
+	assert.Panics(t, func() { publisherKafkaConfigLoader() })
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS", "100")
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES", "-1")
+	defer os.Unsetenv("PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES")
+	assert.Panics(t, func() { publisherKafkaConfigLoader() })
+	os.Setenv("PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES", "102400")
+	publisherKafkaConfigLoader()
+	assert.Equal(t, 100, PublisherKafka.AggregationMaxEvents)
+	assert.Equal(t, 102400, PublisherKafka.AggregationMaxBytes)
+
+	os.Setenv("PUBLISHER_KAFKA_PRODUCER_POOL_SIZE", "0")
+	defer os.Unsetenv("PUBLISHER_KAFKA_PRODUCER_POOL_SIZE")
+	assert.Panics(t, func() { publisherKafkaConfigLoader() })
+}
+
+func TestKafkaConfig_Profiles(t *testing.T) {
+	os.Setenv("PUBLISHER_KAFKA_CLIENT_BOOTSTRAP_SERVERS", "kafka:9092")
+	os.Setenv("PUBLISHER_KAFKA_CLIENT_ACKS", "1")
+	os.Setenv("PUBLISHER_KAFKA_PROFILES", "critical, bulk")
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_CRITICAL_EVENT_TYPES", "payment,login")
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_CRITICAL_CLIENT_ACKS", "all")
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_CRITICAL_CLIENT_ENABLE_IDEMPOTENCE", "true")
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_BULK_EVENT_TYPES", "scroll")
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_BULK_CLIENT_LINGER_MS", "100")
+	defer os.Unsetenv("PUBLISHER_KAFKA_PROFILES")
+	publisherKafkaConfigLoader()
+
+	assert.Equal(t, map[string][]string{"critical": {"payment", "login"}, "bulk": {"scroll"}}, PublisherKafka.Profiles)
+	critical := PublisherKafka.ToProfileKafkaConfigMap("critical")
+	acks, _ := critical.Get("acks", "")
+	idempotence, _ := critical.Get("enable.idempotence", "")
+	bootstrapServer, _ := critical.Get("bootstrap.servers", "")
+	assert.Equal(t, "all", acks)
+	assert.Equal(t, "true", fmt.Sprint(idempotence))
+	assert.Equal(t, "kafka:9092", bootstrapServer)
+	linger, _ := PublisherKafka.ToKafkaConfigMap().Get("linger.ms", "")
+	assert.Equal(t, "", linger)
+
+	os.Setenv("PUBLISHER_KAFKA_PROFILE_BULK_EVENT_TYPES", "scroll,payment")
+	assert.Panics(t, func() { publisherKafkaConfigLoader() })
 }
 
 func TestWorkerConfig(t *testing.T) {
@@ -71,9 +140,214 @@ func TestWorkerConfig(t *testing.T) {
 	os.Setenv("WORKER_BUFFER_CHANNEL_SIZE", "5")
 	os.Setenv("WORKER_KAFKA_DELIVERY_CHANNEL_SIZE", "10")
 	os.Setenv("WORKER_BUFFER_FLUSH_TIMEOUT_MS", "100000")
+	os.Setenv("WORKER_KAFKA_DELIVERY_TIMEOUT_MS", "5000")
 	workerConfigLoader()
+	assert.Equal(t, 5*time.Second, Worker.DeliveryTimeout)
 	assert.Equal(t, time.Duration(100)*time.Second, Worker.WorkerFlushTimeout)
 	assert.Equal(t, 10, Worker.DeliveryChannelSize)
 	assert.Equal(t, 5, Worker.ChannelSize)
 	assert.Equal(t, 2, Worker.WorkersPoolSize)
+	assert.Equal(t, "block", Worker.BufferPolicy)
+	assert.Equal(t, "", Worker.RecoveryFile)
+	assert.Equal(t, "", Worker.QuarantineFile)
+	assert.False(t, Worker.Sharded)
+	assert.Equal(t, time.Duration(0), Worker.CoalesceLinger)
+	assert.Equal(t, 500, Worker.CoalesceMaxEvents)
+	assert.Equal(t, 2, Worker.WorkersPoolMinSize)
+	assert.Equal(t, 2, Worker.WorkersPoolMaxSize)
+
+	os.Setenv("WORKER_POOL_MIN_SIZE", "1")
+	os.Setenv("WORKER_POOL_MAX_SIZE", "8")
+	os.Setenv("WORKER_POOL_SCALE_UP_LATENCY_MS", "200")
+	workerConfigLoader()
+	assert.Equal(t, 1, Worker.WorkersPoolMinSize)
+	assert.Equal(t, 8, Worker.WorkersPoolMaxSize)
+	assert.Equal(t, 75, Worker.ScaleUpBufferPercent)
+	assert.Equal(t, 200*time.Millisecond, Worker.ScaleUpLatency)
+
+	os.Setenv("WORKER_SHARDED", "true")
+	assert.Panics(t, workerConfigLoader)
+	os.Unsetenv("WORKER_SHARDED")
+
+	os.Setenv("WORKER_POOL_MAX_SIZE", "1")
+	assert.Panics(t, workerConfigLoader)
+	os.Unsetenv("WORKER_POOL_MIN_SIZE")
+	os.Unsetenv("WORKER_POOL_MAX_SIZE")
+	os.Unsetenv("WORKER_POOL_SCALE_UP_LATENCY_MS")
+}
+
+func TestWorkerConfig_BufferPolicy(t *testing.T) {
+	os.Setenv("WORKER_BUFFER_POLICY", "reject")
+	os.Setenv("WORKER_BUFFER_TIMEOUT_MS", "50")
+	defer os.Unsetenv("WORKER_BUFFER_POLICY")
+	defer os.Unsetenv("WORKER_BUFFER_TIMEOUT_MS")
+	workerConfigLoader()
+	assert.Equal(t, "reject", Worker.BufferPolicy)
+	assert.Equal(t, 50*time.Millisecond, Worker.BufferTimeout)
+
+	os.Setenv("WORKER_BUFFER_POLICY", "wait")
+	assert.Panics(t, workerConfigLoader)
+}
+
+func TestWorkerConfig_GroupWeights(t *testing.T) {
+	os.Setenv("WORKER_GROUP_QUEUE_SIZE", "20")
+	os.Setenv("WORKER_GROUP_WEIGHTS", "viewer:3, driver : 2")
+	defer os.Unsetenv("WORKER_GROUP_QUEUE_SIZE")
+	defer os.Unsetenv("WORKER_GROUP_WEIGHTS")
+	workerConfigLoader()
+	assert.Equal(t, 20, Worker.GroupQueueSize)
+	assert.Equal(t, map[string]int{"viewer": 3, "driver": 2}, Worker.GroupWeights)
+	assert.Equal(t, 1, Worker.GroupDefaultWeight)
+
+	os.Setenv("WORKER_GROUP_WEIGHTS", "viewer:0")
+	assert.Panics(t, workerConfigLoader)
+	os.Setenv("WORKER_GROUP_WEIGHTS", "viewer")
+	assert.Panics(t, workerConfigLoader)
+}
+
+func TestWorkerConfig_PriorityLane(t *testing.T) {
+	workerConfigLoader()
+	assert.Empty(t, Worker.PriorityEventTypes)
+
+	os.Setenv("WORKER_PRIORITY_EVENT_TYPES", "payment, login")
+	os.Setenv("WORKER_PRIORITY_POOL_SIZE", "3")
+	defer os.Unsetenv("WORKER_PRIORITY_EVENT_TYPES")
+	defer os.Unsetenv("WORKER_PRIORITY_POOL_SIZE")
+	workerConfigLoader()
+	assert.Equal(t, []string{"payment", "login"}, Worker.PriorityEventTypes)
+	assert.Equal(t, 100, Worker.PriorityChannelSize)
+	assert.Equal(t, 3, Worker.PriorityPoolSize)
+
+	os.Setenv("WORKER_PRIORITY_POOL_SIZE", "0")
+	assert.Panics(t, workerConfigLoader)
+}
+
+func TestCollectorConfig(t *testing.T) {
+	collectorConfigLoader()
+	assert.Empty(t, Collector.Middlewares)
+	assert.Equal(t, time.Minute, Collector.DedupTTL)
+	assert.Equal(t, "", Collector.DedupRedisAddr)
+
+	os.Setenv("COLLECTOR_MIDDLEWARES", "skip_empty,dedup")
+	os.Setenv("COLLECTOR_DEDUP_REDIS_ADDR", "redis:6379")
+	defer os.Unsetenv("COLLECTOR_MIDDLEWARES")
+	defer os.Unsetenv("COLLECTOR_DEDUP_REDIS_ADDR")
+	collectorConfigLoader()
+	assert.Equal(t, []string{"skip_empty", "dedup"}, Collector.Middlewares)
+	assert.Equal(t, "redis:6379", Collector.DedupRedisAddr)
+	assert.Equal(t, 100*time.Millisecond, Collector.DedupRedisTimeout)
+
+	os.Setenv("COLLECTOR_MIDDLEWARES", "json,validate")
+	collectorConfigLoader()
+	assert.Equal(t, []string{"json", "validate"}, Collector.Middlewares)
+	os.Setenv("COLLECTOR_MIDDLEWARES", "validate,json")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_MIDDLEWARES", "redact,json,validate")
+	assert.Panics(t, collectorConfigLoader)
+}
+
+func TestCollectorConfig_Filter(t *testing.T) {
+	collectorConfigLoader()
+	assert.Empty(t, Collector.FilterAllow)
+	assert.Equal(t, "drop", Collector.FilterAction)
+
+	os.Setenv("COLLECTOR_FILTER_ALLOW", "driver:location | booking")
+	os.Setenv("COLLECTOR_FILTER_DENY", "*:debug,viewer:scroll")
+	os.Setenv("COLLECTOR_FILTER_ACTION", "quarantine")
+	defer os.Unsetenv("COLLECTOR_FILTER_ALLOW")
+	defer os.Unsetenv("COLLECTOR_FILTER_DENY")
+	defer os.Unsetenv("COLLECTOR_FILTER_ACTION")
+	collectorConfigLoader()
+	assert.Equal(t, map[string][]string{"driver": {"location", "booking"}}, Collector.FilterAllow)
+	assert.Equal(t, map[string][]string{"*": {"debug"}, "viewer": {"scroll"}}, Collector.FilterDeny)
+	assert.Equal(t, "quarantine", Collector.FilterAction)
+
+	os.Setenv("COLLECTOR_FILTER_ACTION", "ignore")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_FILTER_ACTION", "drop")
+	os.Setenv("COLLECTOR_FILTER_DENY", "debug")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_FILTER_DENY", "")
+
+	os.Setenv("COLLECTOR_SAMPLE_RATES", "*:scroll:0.1, viewer:*:0.5,*:debug:0")
+	defer os.Unsetenv("COLLECTOR_SAMPLE_RATES")
+	collectorConfigLoader()
+	assert.Equal(t, map[string]map[string]float64{"*": {"scroll": 0.1, "debug": 0}, "viewer": {"*": 0.5}}, Collector.SampleRates)
+
+	os.Setenv("COLLECTOR_SAMPLE_RATES", "*:scroll:2")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_SAMPLE_RATES", "scroll:0.1")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_SAMPLE_RATES", "")
+
+	os.Setenv("COLLECTOR_SCHEMA_DESCRIPTOR_FILE", "/etc/raccoon/descriptors.pb")
+	os.Setenv("COLLECTOR_SCHEMA_MESSAGES", "click:clickstream.Click, scroll:clickstream.Scroll")
+	os.Setenv("COLLECTOR_JSON_EVENT_TYPES", "click")
+	os.Setenv("COLLECTOR_VALIDATION_REQUIRE_FIELDS", "true")
+	defer os.Unsetenv("COLLECTOR_SCHEMA_DESCRIPTOR_FILE")
+	defer os.Unsetenv("COLLECTOR_SCHEMA_MESSAGES")
+	defer os.Unsetenv("COLLECTOR_JSON_EVENT_TYPES")
+	defer os.Unsetenv("COLLECTOR_VALIDATION_REQUIRE_FIELDS")
+	collectorConfigLoader()
+	assert.Equal(t, "/etc/raccoon/descriptors.pb", Collector.SchemaDescriptorFile)
+	assert.Equal(t, map[string]string{"click": "clickstream.Click", "scroll": "clickstream.Scroll"}, Collector.SchemaMessages)
+	assert.Equal(t, []string{"click"}, Collector.JSONEventTypes)
+	assert.True(t, Collector.ValidationRequireFields)
+	assert.Equal(t, "reject", Collector.ValidationAction)
+
+	os.Setenv("COLLECTOR_VALIDATION_ACTION", "ignore")
+	defer os.Unsetenv("COLLECTOR_VALIDATION_ACTION")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_VALIDATION_ACTION", "reject")
+	os.Setenv("COLLECTOR_SCHEMA_MESSAGES", "clickstream.Click")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_SCHEMA_MESSAGES", "")
+
+	os.Setenv("COLLECTOR_REDACT_FIELDS", "signup:email:hash, signup:contacts.phone:mask,login:email:drop")
+	os.Setenv("COLLECTOR_REDACT_KEY_FILE", "/etc/raccoon/redact.key")
+	defer os.Unsetenv("COLLECTOR_REDACT_FIELDS")
+	defer os.Unsetenv("COLLECTOR_REDACT_KEY_FILE")
+	collectorConfigLoader()
+	assert.Equal(t, map[string]map[string]string{"signup": {"email": "hash", "contacts.phone": "mask"}, "login": {"email": "drop"}}, Collector.RedactFields)
+	assert.Equal(t, "/etc/raccoon/redact.key", Collector.RedactKeyFile)
+	assert.Equal(t, time.Minute, Collector.RedactKeyReload)
+
+	os.Setenv("COLLECTOR_REDACT_FIELDS", "signup:email:encrypt")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_REDACT_FIELDS", "signup:email")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_REDACT_FIELDS", "")
+
+	os.Setenv("COLLECTOR_ENRICH_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	os.Setenv("COLLECTOR_ENRICH_GEO_DATABASE", "/usr/share/GeoIP/GeoLite2-City.mmdb")
+	defer os.Unsetenv("COLLECTOR_ENRICH_TRUSTED_PROXIES")
+	defer os.Unsetenv("COLLECTOR_ENRICH_GEO_DATABASE")
+	collectorConfigLoader()
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1"}, Collector.EnrichTrustedProxies)
+	assert.Equal(t, "/usr/share/GeoIP/GeoLite2-City.mmdb", Collector.EnrichGeoDatabase)
+
+	assert.Equal(t, time.Minute, Collector.ClockSkewThreshold)
+	os.Setenv("COLLECTOR_CLOCK_SKEW_THRESHOLD_MS", "0")
+	defer os.Unsetenv("COLLECTOR_CLOCK_SKEW_THRESHOLD_MS")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_CLOCK_SKEW_THRESHOLD_MS", "60000")
+
+	os.Setenv("COLLECTOR_TIMELINESS_MAX_AGE_MS", "86400000")
+	os.Setenv("COLLECTOR_TIMELINESS_STALE_ACTION", "reroute")
+	os.Setenv("COLLECTOR_TIMELINESS_FUTURE_ACTION", "reject")
+	defer os.Unsetenv("COLLECTOR_TIMELINESS_MAX_AGE_MS")
+	defer os.Unsetenv("COLLECTOR_TIMELINESS_STALE_ACTION")
+	defer os.Unsetenv("COLLECTOR_TIMELINESS_FUTURE_ACTION")
+	collectorConfigLoader()
+	assert.Equal(t, 24*time.Hour, Collector.TimelinessMaxAge)
+	assert.Equal(t, "reroute", Collector.TimelinessStaleAction)
+	assert.Equal(t, time.Duration(0), Collector.TimelinessMaxAhead)
+	assert.Equal(t, "reject", Collector.TimelinessFutureAction)
+	assert.Equal(t, "late", Collector.TimelinessRerouteType)
+
+	os.Setenv("COLLECTOR_TIMELINESS_FUTURE_ACTION", "drop")
+	assert.Panics(t, collectorConfigLoader)
+	os.Setenv("COLLECTOR_TIMELINESS_FUTURE_ACTION", "reject")
+	os.Setenv("COLLECTOR_TIMELINESS_MAX_AGE_MS", "-1")
+	assert.Panics(t, collectorConfigLoader)
 }
@@ -2,8 +2,10 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/odpf/raccoon/config/util"
 	"github.com/spf13/viper"
@@ -12,26 +14,59 @@ import (
 
 var PublisherKafka publisherKafka
 var dynamicKafkaClientConfigPrefix = "PUBLISHER_KAFKA_CLIENT_"
+var dynamicKafkaProfileConfigPrefix = "PUBLISHER_KAFKA_PROFILE_"
 
 type publisherKafka struct {
 	FlushInterval int
+	// MockBrokers is the number of librdkafka mock brokers to publish to instead of the configured bootstrap servers.
+	// Zero disables the mock cluster.
+	MockBrokers int
+	// ProducerPoolSize is the number of publishers the workers are spread over, each with its own librdkafka producers.
+	ProducerPoolSize int
+	// Profiles maps the name of each producer profile to the event types published through it.
+	// Event types without profile are published with the default producer.
+	Profiles map[string][]string
+	// AggregationEventTypes are the event types packed into aggregated records instead of one record per event.
+	AggregationEventTypes []string
+	// AggregationMaxEvents is the maximum number of events in an aggregated record.
+	AggregationMaxEvents int
+	// AggregationMaxBytes is the maximum size of the events in an aggregated record.
+	AggregationMaxBytes int
+	// AggregationLinger is the maximum time an event waits to be aggregated with others.
+	AggregationLinger time.Duration
+	// JSONEventTypes are the event types published as the JSON of their message instead of the bytes received.
+	JSONEventTypes []string
 }
 
 func (k publisherKafka) ToKafkaConfigMap() *confluent.ConfigMap {
-	configMap := &confluent.ConfigMap{}
+	return toKafkaConfigMap(dynamicKafkaClientConfigPrefix, &confluent.ConfigMap{})
+}
+
+// ToProfileKafkaConfigMap builds the client config of a producer profile.
+// Profile client configs override the ones of the default producer, which are inherited otherwise.
+func (k publisherKafka) ToProfileKafkaConfigMap(profile string) *confluent.ConfigMap {
+	return toKafkaConfigMap(profileClientConfigPrefix(profile), k.ToKafkaConfigMap())
+}
+
+func toKafkaConfigMap(prefix string, configMap *confluent.ConfigMap) *confluent.ConfigMap {
 	for key, value := range viper.AllSettings() {
-		if strings.HasPrefix(strings.ToUpper(key), dynamicKafkaClientConfigPrefix) {
-			clientConfig := key[len(dynamicKafkaClientConfigPrefix):]
+		if strings.HasPrefix(strings.ToUpper(key), prefix) {
+			clientConfig := key[len(prefix):]
 			configMap.SetKey(strings.Join(strings.Split(clientConfig, "_"), "."), value)
 		}
 	}
 	return configMap
 }
 
+func profileClientConfigPrefix(profile string) string {
+	return fmt.Sprintf("%s%s_CLIENT_", dynamicKafkaProfileConfigPrefix, strings.ToUpper(profile))
+}
+
 func dynamicKafkaClientConfigLoad() []byte {
 	var kafkaConfigs []string
 	for _, v := range os.Environ() {
-		if strings.HasPrefix(strings.ToUpper(v), dynamicKafkaClientConfigPrefix) {
+		if strings.HasPrefix(strings.ToUpper(v), dynamicKafkaClientConfigPrefix) ||
+			strings.HasPrefix(strings.ToUpper(v), dynamicKafkaProfileConfigPrefix) {
 			kafkaConfigs = append(kafkaConfigs, v)
 		}
 	}
@@ -40,12 +75,64 @@ func dynamicKafkaClientConfigLoad() []byte {
 	return yamlFormatted
 }
 
+func profilesConfigLoader() map[string][]string {
+	profiles := make(map[string][]string)
+	profileOf := make(map[string]string)
+	for _, name := range util.MustGetStringSlice("PUBLISHER_KAFKA_PROFILES") {
+		name = strings.ToLower(name)
+		eventTypesKey := fmt.Sprintf("%s%s_EVENT_TYPES", dynamicKafkaProfileConfigPrefix, strings.ToUpper(name))
+		viper.SetDefault(eventTypesKey, "")
+		eventTypes := util.MustGetStringSlice(eventTypesKey)
+		for _, eventType := range eventTypes {
+			if other, ok := profileOf[eventType]; ok {
+				panic(fmt.Sprintf("event type %s is mapped to both %s and %s producer profiles", eventType, other, name))
+			}
+			profileOf[eventType] = name
+		}
+		profiles[name] = eventTypes
+	}
+	return profiles
+}
+
 func publisherKafkaConfigLoader() {
 	viper.SetDefault("PUBLISHER_KAFKA_CLIENT_QUEUE_BUFFERING_MAX_MESSAGES", "100000")
 	viper.SetDefault("PUBLISHER_KAFKA_FLUSH_INTERVAL_MS", "1000")
+	viper.SetDefault("PUBLISHER_KAFKA_MOCK_BROKERS", "0")
+	viper.SetDefault("PUBLISHER_KAFKA_PROFILES", "")
+	viper.SetDefault("PUBLISHER_KAFKA_PRODUCER_POOL_SIZE", "1")
+	viper.SetDefault("PUBLISHER_KAFKA_AGGREGATION_EVENT_TYPES", "")
+	viper.SetDefault("PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS", "100")
+	viper.SetDefault("PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES", "102400")
+	viper.SetDefault("PUBLISHER_KAFKA_AGGREGATION_LINGER_MS", "500")
+	viper.SetDefault("PUBLISHER_KAFKA_JSON_EVENT_TYPES", "")
 	viper.MergeConfig(bytes.NewBuffer(dynamicKafkaClientConfigLoad()))
 
 	PublisherKafka = publisherKafka{
-		FlushInterval: util.MustGetInt("PUBLISHER_KAFKA_FLUSH_INTERVAL_MS"),
+		FlushInterval:    util.MustGetInt("PUBLISHER_KAFKA_FLUSH_INTERVAL_MS"),
+		MockBrokers:      util.MustGetInt("PUBLISHER_KAFKA_MOCK_BROKERS"),
+		ProducerPoolSize: util.MustGetInt("PUBLISHER_KAFKA_PRODUCER_POOL_SIZE"),
+		Profiles:         profilesConfigLoader(),
+
+		AggregationEventTypes: util.MustGetStringSlice("PUBLISHER_KAFKA_AGGREGATION_EVENT_TYPES"),
+		AggregationMaxEvents:  util.MustGetInt("PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS"),
+		AggregationMaxBytes:   util.MustGetInt("PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES"),
+		AggregationLinger:     util.MustGetDuration("PUBLISHER_KAFKA_AGGREGATION_LINGER_MS", time.Millisecond),
+		JSONEventTypes:        util.MustGetStringSlice("PUBLISHER_KAFKA_JSON_EVENT_TYPES"),
+	}
+	if PublisherKafka.ProducerPoolSize < 1 {
+		panic("key PUBLISHER_KAFKA_PRODUCER_POOL_SIZE should be at least 1")
+	}
+	if PublisherKafka.AggregationMaxEvents < 1 {
+		panic("key PUBLISHER_KAFKA_AGGREGATION_MAX_EVENTS should be at least 1")
+	}
+	if PublisherKafka.AggregationMaxBytes < 1 {
+		panic("key PUBLISHER_KAFKA_AGGREGATION_MAX_BYTES should be at least 1")
+	}
+	for _, t := range PublisherKafka.JSONEventTypes {
+		for _, aggregated := range PublisherKafka.AggregationEventTypes {
+			if t == aggregated {
+				panic(fmt.Sprintf("key PUBLISHER_KAFKA_JSON_EVENT_TYPES should not list aggregated event types, got %s", t))
+			}
+		}
 	}
 }
@@ -9,6 +9,7 @@ import (
 
 var ServerWs serverWs
 var ServerGRPC serverGRPC
+var ServerAdmin serverAdmin
 
 type serverWs struct {
 	AppPort           string
@@ -23,12 +24,19 @@ type serverWs struct {
 	ConnIDHeader      string
 	ConnGroupHeader   string
 	ConnGroupDefault  string
+	// TimeEndpoint serves the time of the server for the clients to sync their clock with
+	TimeEndpoint bool
 }
 
 type serverGRPC struct {
 	Port string
 }
 
+type serverAdmin struct {
+	// Addr is the address the admin endpoint listens on, empty disables it
+	Addr string
+}
+
 func serverWsConfigLoader() {
 	viper.SetDefault("SERVER_WEBSOCKET_PORT", "8080")
 	viper.SetDefault("SERVER_WEBSOCKET_MAX_CONN", 30000)
@@ -41,6 +49,7 @@ func serverWsConfigLoader() {
 	viper.SetDefault("SERVER_WEBSOCKET_PINGER_SIZE", 1)
 	viper.SetDefault("SERVER_WEBSOCKET_CONN_GROUP_HEADER", "")
 	viper.SetDefault("SERVER_WEBSOCKET_CONN_GROUP_DEFAULT", "--default--")
+	viper.SetDefault("SERVER_WEBSOCKET_TIME_ENDPOINT", false)
 
 	ServerWs = serverWs{
 		AppPort:           util.MustGetString("SERVER_WEBSOCKET_PORT"),
@@ -55,6 +64,7 @@ func serverWsConfigLoader() {
 		ConnIDHeader:      util.MustGetString("SERVER_WEBSOCKET_CONN_ID_HEADER"),
 		ConnGroupHeader:   util.MustGetString("SERVER_WEBSOCKET_CONN_GROUP_HEADER"),
 		ConnGroupDefault:  util.MustGetString("SERVER_WEBSOCKET_CONN_GROUP_DEFAULT"),
+		TimeEndpoint:      util.MustGetBool("SERVER_WEBSOCKET_TIME_ENDPOINT"),
 	}
 }
 
@@ -65,3 +75,10 @@ func serverGRPCConfigLoader() {
 		Port: util.MustGetString("SERVER_GRPC_PORT"),
 	}
 }
+
+func serverAdminConfigLoader() {
+	viper.SetDefault("SERVER_ADMIN_ADDR", "")
+	ServerAdmin = serverAdmin{
+		Addr: util.MustGetString("SERVER_ADMIN_ADDR"),
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -28,6 +29,18 @@ func MustGetBool(key string) bool {
 	return viper.GetBool(key)
 }
 
+// MustGetStringSlice reads a comma separated value. Blank items are skipped.
+func MustGetStringSlice(key string) []string {
+	mustHave(key)
+	var items []string
+	for _, item := range strings.Split(viper.GetString(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func MustGetDuration(key string, d time.Duration) time.Duration {
 	return d * time.Duration(MustGetInt(key))
 }
@@ -39,6 +39,14 @@ func TestConfigUtil(t *testing.T) {
 		})
 	})
 
+	t.Run("MustGetStringSlice", func(t *testing.T) {
+		t.Run("Should split and trim comma separated value", func(t *testing.T) {
+			os.Setenv("SLICE_CONFIG", "a, b,,c ")
+			viper.AutomaticEnv()
+			assert.Equal(t, []string{"a", "b", "c"}, MustGetStringSlice("SLICE_CONFIG"))
+		})
+	})
+
 	t.Run("MustGetDuration", func(t *testing.T) {
 		t.Run("Should get correct value", func(t *testing.T) {
 			os.Setenv("DURATION_CONFIG", "20")
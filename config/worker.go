@@ -1,6 +1,9 @@
 package config
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/odpf/raccoon/config/util"
@@ -15,11 +18,52 @@ type worker struct {
 	WorkersPoolSize int
 	// ChannelSize channel size to buffer events before processed by worker
 	ChannelSize int
+	// BufferPolicy tells what to do with a batch when the buffer is full, one of block, reject, drop_oldest and drop_newest
+	BufferPolicy string
+	// BufferTimeout bounds the wait of the block policy. Zero waits until the request is cancelled.
+	BufferTimeout time.Duration
 	//DeliveryChannelSize fetches the size of the delivery channel as configured
 	DeliveryChannelSize int
 	//WorkerFlushTimeout specifies a timeout interval that the workers use to timeout
 	//in case the workers could not complete the flush. This enables a non-blocking flush.
 	WorkerFlushTimeout time.Duration
+	// DeliveryTimeout bounds the time a worker waits for the delivery reports of a batch. Zero waits without deadline.
+	DeliveryTimeout time.Duration
+	// WorkersPoolMinSize and WorkersPoolMaxSize bound the pool when autoscaling. The pool is fixed when they are equal.
+	WorkersPoolMinSize int
+	WorkersPoolMaxSize int
+	// ScaleInterval is the time between two autoscaling decisions
+	ScaleInterval time.Duration
+	// ScaleUpBufferPercent and ScaleDownBufferPercent are the buffer channel occupancies triggering a scaling decision
+	ScaleUpBufferPercent   int
+	ScaleDownBufferPercent int
+	// ScaleUpLatency is the mean publish latency of a batch adding a worker. Zero ignores the latency.
+	ScaleUpLatency time.Duration
+	// GroupQueueSize is the size of the queue of each connection group. Zero disables the per group queues.
+	GroupQueueSize int
+	// GroupWeights is the number of batches taken from the queue of a group on each scheduling round
+	GroupWeights map[string]int
+	// GroupDefaultWeight is the weight of the groups missing from GroupWeights
+	GroupDefaultWeight int
+	// Sharded routes the requests of a connection to the same worker, preserving their order
+	Sharded bool
+	// ShardChannelSize is the size of the channel of each worker when sharded
+	ShardChannelSize int
+	// CoalesceLinger is the maximum time a batch waits to be published along with others of its group. Zero disables coalescing.
+	CoalesceLinger time.Duration
+	// CoalesceMaxEvents and CoalesceMaxBytes bound the batches published at once
+	CoalesceMaxEvents int
+	CoalesceMaxBytes  int
+	// PriorityEventTypes are the event types going through the priority lane. Empty disables the lane.
+	PriorityEventTypes []string
+	// PriorityChannelSize and PriorityPoolSize are the size of the buffer and of the worker set reserved to the priority lane
+	PriorityChannelSize int
+	PriorityPoolSize    int
+	// RecoveryFile is where the events left unpublished on shutdown are persisted, to be replayed on the next start.
+	// Empty disables the recovery.
+	RecoveryFile string
+	// QuarantineFile is where the batches a worker panicked on are appended. Empty drops them.
+	QuarantineFile string
 }
 
 //workerConfigLoader constructs a singleton instance of the worker pool config
@@ -27,12 +71,103 @@ func workerConfigLoader() {
 	viper.SetDefault("WORKER_POOL_SIZE", 5)
 	viper.SetDefault("WORKER_BUFFER_CHANNEL_SIZE", 100)
 	viper.SetDefault("WORKER_BUFFER_FLUSH_TIMEOUT_MS", 5000)
+	viper.SetDefault("WORKER_BUFFER_POLICY", "block")
+	viper.SetDefault("WORKER_BUFFER_TIMEOUT_MS", 0)
 	viper.SetDefault("WORKER_KAFKA_DELIVERY_CHANNEL_SIZE", 10)
+	viper.SetDefault("WORKER_KAFKA_DELIVERY_TIMEOUT_MS", 0)
+	viper.SetDefault("WORKER_POOL_MIN_SIZE", 0)
+	viper.SetDefault("WORKER_POOL_MAX_SIZE", 0)
+	viper.SetDefault("WORKER_POOL_SCALE_INTERVAL_MS", 10000)
+	viper.SetDefault("WORKER_POOL_SCALE_UP_BUFFER_PERCENT", 75)
+	viper.SetDefault("WORKER_POOL_SCALE_DOWN_BUFFER_PERCENT", 10)
+	viper.SetDefault("WORKER_POOL_SCALE_UP_LATENCY_MS", 0)
+	viper.SetDefault("WORKER_GROUP_QUEUE_SIZE", 0)
+	viper.SetDefault("WORKER_GROUP_WEIGHTS", "")
+	viper.SetDefault("WORKER_GROUP_DEFAULT_WEIGHT", 1)
+	viper.SetDefault("WORKER_RECOVERY_FILE", "")
+	viper.SetDefault("WORKER_QUARANTINE_FILE", "")
+	viper.SetDefault("WORKER_SHARDED", false)
+	viper.SetDefault("WORKER_COALESCE_LINGER_MS", 0)
+	viper.SetDefault("WORKER_COALESCE_MAX_EVENTS", 500)
+	viper.SetDefault("WORKER_COALESCE_MAX_BYTES", 1048576)
+	viper.SetDefault("WORKER_SHARD_CHANNEL_SIZE", 10)
+	viper.SetDefault("WORKER_PRIORITY_EVENT_TYPES", "")
+	viper.SetDefault("WORKER_PRIORITY_CHANNEL_SIZE", 100)
+	viper.SetDefault("WORKER_PRIORITY_POOL_SIZE", 1)
 
 	Worker = worker{
 		WorkersPoolSize:     util.MustGetInt("WORKER_POOL_SIZE"),
 		ChannelSize:         util.MustGetInt("WORKER_BUFFER_CHANNEL_SIZE"),
+		BufferPolicy:        util.MustGetString("WORKER_BUFFER_POLICY"),
+		BufferTimeout:       util.MustGetDuration("WORKER_BUFFER_TIMEOUT_MS", time.Millisecond),
 		DeliveryChannelSize: util.MustGetInt("WORKER_KAFKA_DELIVERY_CHANNEL_SIZE"),
 		WorkerFlushTimeout:  util.MustGetDuration("WORKER_BUFFER_FLUSH_TIMEOUT_MS", time.Millisecond),
+		DeliveryTimeout:     util.MustGetDuration("WORKER_KAFKA_DELIVERY_TIMEOUT_MS", time.Millisecond),
+
+		WorkersPoolMinSize:     util.MustGetInt("WORKER_POOL_MIN_SIZE"),
+		WorkersPoolMaxSize:     util.MustGetInt("WORKER_POOL_MAX_SIZE"),
+		ScaleInterval:          util.MustGetDuration("WORKER_POOL_SCALE_INTERVAL_MS", time.Millisecond),
+		ScaleUpBufferPercent:   util.MustGetInt("WORKER_POOL_SCALE_UP_BUFFER_PERCENT"),
+		ScaleDownBufferPercent: util.MustGetInt("WORKER_POOL_SCALE_DOWN_BUFFER_PERCENT"),
+		ScaleUpLatency:         util.MustGetDuration("WORKER_POOL_SCALE_UP_LATENCY_MS", time.Millisecond),
+
+		GroupQueueSize:     util.MustGetInt("WORKER_GROUP_QUEUE_SIZE"),
+		GroupWeights:       groupWeightsConfigLoader(),
+		GroupDefaultWeight: util.MustGetInt("WORKER_GROUP_DEFAULT_WEIGHT"),
+		RecoveryFile:       util.MustGetString("WORKER_RECOVERY_FILE"),
+		QuarantineFile:     util.MustGetString("WORKER_QUARANTINE_FILE"),
+		Sharded:            util.MustGetBool("WORKER_SHARDED"),
+		ShardChannelSize:   util.MustGetInt("WORKER_SHARD_CHANNEL_SIZE"),
+		CoalesceLinger:     util.MustGetDuration("WORKER_COALESCE_LINGER_MS", time.Millisecond),
+		CoalesceMaxEvents:  util.MustGetInt("WORKER_COALESCE_MAX_EVENTS"),
+		CoalesceMaxBytes:   util.MustGetInt("WORKER_COALESCE_MAX_BYTES"),
+
+		PriorityEventTypes:  util.MustGetStringSlice("WORKER_PRIORITY_EVENT_TYPES"),
+		PriorityChannelSize: util.MustGetInt("WORKER_PRIORITY_CHANNEL_SIZE"),
+		PriorityPoolSize:    util.MustGetInt("WORKER_PRIORITY_POOL_SIZE"),
+	}
+	// the pool bounds default to the fixed pool size
+	if Worker.WorkersPoolMinSize == 0 {
+		Worker.WorkersPoolMinSize = Worker.WorkersPoolSize
+	}
+	if Worker.WorkersPoolMaxSize == 0 {
+		Worker.WorkersPoolMaxSize = Worker.WorkersPoolSize
+	}
+	if Worker.WorkersPoolMinSize < 1 || Worker.WorkersPoolSize < Worker.WorkersPoolMinSize || Worker.WorkersPoolMaxSize < Worker.WorkersPoolSize {
+		panic("keys WORKER_POOL_MIN_SIZE, WORKER_POOL_SIZE and WORKER_POOL_MAX_SIZE should be ordered and at least 1")
+	}
+	if Worker.WorkersPoolMaxSize > Worker.WorkersPoolMinSize && Worker.ScaleInterval <= 0 {
+		panic("key WORKER_POOL_SCALE_INTERVAL_MS should be positive when autoscaling")
+	}
+	if Worker.Sharded && Worker.WorkersPoolMaxSize > Worker.WorkersPoolMinSize {
+		panic("key WORKER_SHARDED cannot be set along with WORKER_POOL_MIN_SIZE and WORKER_POOL_MAX_SIZE, sharded pools are not autoscaled")
+	}
+	switch Worker.BufferPolicy {
+	case "block", "reject", "drop_oldest", "drop_newest":
+	default:
+		panic(fmt.Sprintf("key WORKER_BUFFER_POLICY should be one of block, reject, drop_oldest and drop_newest, got %s", Worker.BufferPolicy))
+	}
+	if Worker.GroupDefaultWeight < 1 {
+		panic("key WORKER_GROUP_DEFAULT_WEIGHT should be at least 1")
+	}
+	if len(Worker.PriorityEventTypes) > 0 && Worker.PriorityPoolSize < 1 {
+		panic("key WORKER_PRIORITY_POOL_SIZE should be at least 1 when WORKER_PRIORITY_EVENT_TYPES is set")
+	}
+}
+
+// groupWeightsConfigLoader parses WORKER_GROUP_WEIGHTS, a comma separated list of group:weight
+func groupWeightsConfigLoader() map[string]int {
+	weights := make(map[string]int)
+	for _, groupWeight := range util.MustGetStringSlice("WORKER_GROUP_WEIGHTS") {
+		i := strings.LastIndex(groupWeight, ":")
+		if i < 1 {
+			panic(fmt.Sprintf("key WORKER_GROUP_WEIGHTS should be a list of group:weight, got %s", groupWeight))
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(groupWeight[i+1:]))
+		if err != nil || weight < 1 {
+			panic(fmt.Sprintf("key WORKER_GROUP_WEIGHTS should have weights of at least 1, got %s", groupWeight))
+		}
+		weights[strings.TrimSpace(groupWeight[:i])] = weight
 	}
+	return weights
 }
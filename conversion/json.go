@@ -0,0 +1,82 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// JSONToProto is a collector stage converting the events of some types, sent as JSON, to the protobuf message of their
+// type, so that clients can send events without encoding protobuf.
+type JSONToProto struct {
+	registry *schema.Registry
+	types    map[string]struct{}
+}
+
+// NewJSONToProto creates a stage converting the events of types to the messages of registry. Every type must have a
+// message in registry. Events of the other types are not converted.
+func NewJSONToProto(registry *schema.Registry, types []string) (*JSONToProto, error) {
+	c := &JSONToProto{registry: registry, types: make(map[string]struct{}, len(types))}
+	for _, t := range types {
+		if _, ok := registry.Message(t); !ok {
+			return nil, fmt.Errorf("event type %s has no message", t)
+		}
+		c.types[t] = struct{}{}
+	}
+	return c, nil
+}
+
+// convert returns the protobuf bytes of the event, false when it is not to be converted.
+func (c *JSONToProto) convert(e *pb.Event) ([]byte, bool, error) {
+	if _, ok := c.types[e.Type]; !ok {
+		return nil, false, nil
+	}
+	md, _ := c.registry.Message(e.Type)
+	m := dynamicpb.NewMessage(md)
+	// the required fields are left to the validate stage
+	if err := (protojson.UnmarshalOptions{AllowPartial: true}).Unmarshal(e.EventBytes, m); err != nil {
+		return nil, true, err
+	}
+	// without Deterministic, dynamic messages are marshaled in no particular field order
+	b, err := proto.MarshalOptions{AllowPartial: true, Deterministic: true}.Marshal(m)
+	return b, true, err
+}
+
+// Middleware converts the events of the converted types of every request. A request with an event which cannot be converted
+// fails with an error wrapping collection.ErrInvalidRequest, giving the reason for every such event.
+func (c *JSONToProto) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		var events []*pb.Event
+		var reasons []string
+		for i, e := range req.GetEvents() {
+			b, ok, err := c.convert(e)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("event %d of type %s: %v", i, e.Type, err))
+				continue
+			}
+			if !ok {
+				events = append(events, e)
+				continue
+			}
+			metrics.Increment("events_converted_total", fmt.Sprintf("from=json,conn_group=%s,event_type=%s", group, e.Type))
+			converted := &pb.Event{EventBytes: b, Type: e.Type}
+			req.MoveEventHeaders(e, converted)
+			events = append(events, converted)
+		}
+		if len(reasons) > 0 {
+			metrics.Count("events_conversion_failed_total", len(reasons), fmt.Sprintf("from=json,conn_group=%s", group))
+			return fmt.Errorf("%w: %s", collection.ErrInvalidRequest, strings.Join(reasons, "; "))
+		}
+		req.Events = events
+		return next.Collect(ctx, req)
+	})
+}
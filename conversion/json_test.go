@@ -0,0 +1,95 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// registry maps the click type to the test.Click message, having an id and a timestamp.
+func registry(t *testing.T) *schema.Registry {
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Click"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("sent_ts"), JsonName: proto.String("sentTs"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+			},
+		}},
+	}}}
+	r, err := schema.New(set, map[string]string{"click": "test.Click"})
+	require.NoError(t, err)
+	return r
+}
+
+func requestOf(events ...*pb.Event) *collection.CollectRequest {
+	return &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: "web"},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc", Events: events},
+	}
+}
+
+func TestJSONToProto_Middleware(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewJSONToProto(registry(t), []string{"click", "scroll"})
+	assert.Error(t, err)
+	j, err := NewJSONToProto(registry(t), []string{"click"})
+	require.NoError(t, err)
+	click := []byte{0x0a, 0x01, 'a', 0x10, 0x01}
+
+	t.Run("Should convert the events of the converted types", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		req := requestOf(
+			&pb.Event{EventBytes: []byte(`{"id": "a", "sentTs": "1"}`), Type: "click"},
+			&pb.Event{EventBytes: []byte(`{"id": "a", "sent_ts": 1}`), Type: "click"},
+			&pb.Event{EventBytes: []byte(`{"offset": 10}`), Type: "scroll"},
+		)
+		assert.NoError(t, j.Middleware(c).Collect(ctx, req))
+		require.Len(t, req.Events, 3)
+		assert.Equal(t, click, req.Events[0].EventBytes)
+		assert.Equal(t, click, req.Events[1].EventBytes)
+		assert.Equal(t, []byte(`{"offset": 10}`), req.Events[2].EventBytes)
+		assert.Equal(t, "click", req.Events[0].Type)
+		c.AssertNumberOfCalls(t, "Collect", 1)
+	})
+
+	t.Run("Should leave the events of the other types as sent", func(t *testing.T) {
+		unconverted, err := NewJSONToProto(registry(t), nil)
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		req := requestOf(&pb.Event{EventBytes: []byte(`{"id": "a"}`), Type: "click"})
+		assert.NoError(t, unconverted.Middleware(c).Collect(ctx, req))
+		assert.Equal(t, []byte(`{"id": "a"}`), req.Events[0].EventBytes)
+	})
+
+	t.Run("Should reject a request with events which cannot be converted", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		err := j.Middleware(c).Collect(ctx, requestOf(
+			&pb.Event{EventBytes: []byte(`{"id": "a"}`), Type: "click"},
+			&pb.Event{EventBytes: []byte(`{"id": 1}`), Type: "click"},
+			&pb.Event{EventBytes: []byte(`{"unknown": "a"}`), Type: "click"},
+			&pb.Event{EventBytes: click, Type: "click"},
+		))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+		assert.Contains(t, err.Error(), "event 1 of type click")
+		assert.Contains(t, err.Error(), "event 2 of type click")
+		assert.Contains(t, err.Error(), "event 3 of type click")
+		assert.NotContains(t, err.Error(), "event 0")
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+}
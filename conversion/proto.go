@@ -0,0 +1,55 @@
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/odpf/raccoon/schema"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoToJSON renders the protobuf bytes of the events of some types into the JSON mapping of their message, for
+// consumers which only read JSON. The JSON is compact, with the fields in the order of their number and the map
+// entries sorted by key, so that the same event is always rendered into the same bytes.
+type ProtoToJSON struct {
+	registry *schema.Registry
+	types    map[string]struct{}
+}
+
+// NewProtoToJSON creates a formatter rendering the events of types with the messages of registry. Every type must
+// have a message in registry.
+func NewProtoToJSON(registry *schema.Registry, types []string) (*ProtoToJSON, error) {
+	p := &ProtoToJSON{registry: registry, types: make(map[string]struct{}, len(types))}
+	for _, t := range types {
+		if _, ok := registry.Message(t); !ok {
+			return nil, fmt.Errorf("event type %s has no message", t)
+		}
+		p.types[t] = struct{}{}
+	}
+	return p, nil
+}
+
+// Format returns the JSON of the event, false when its type is not rendered.
+func (p *ProtoToJSON) Format(eventType string, b []byte) ([]byte, bool, error) {
+	if _, ok := p.types[eventType]; !ok {
+		return nil, false, nil
+	}
+	md, _ := p.registry.Message(eventType)
+	m := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(b, m); err != nil {
+		return nil, true, err
+	}
+	rendered, err := protojson.MarshalOptions{AllowPartial: true}.Marshal(m)
+	if err != nil {
+		return nil, true, err
+	}
+	// protojson randomly adds whitespace so that its output is not relied upon, it is removed
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, rendered); err != nil {
+		return nil, true, err
+	}
+	return compact.Bytes(), true, nil
+}
@@ -0,0 +1,33 @@
+package conversion
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoToJSON_Format(t *testing.T) {
+	r := registry(t)
+	_, err := NewProtoToJSON(r, []string{"click", "scroll"})
+	assert.Error(t, err)
+	p, err := NewProtoToJSON(r, []string{"click"})
+	require.NoError(t, err)
+
+	b, ok, err := p.Format("click", []byte{0x0a, 0x01, 'a', 0x10, 0x01})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `{"id":"a","sentTs":"1"}`, string(b))
+	var rendered map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &rendered))
+	assert.Equal(t, map[string]interface{}{"id": "a", "sentTs": "1"}, rendered)
+
+	_, ok, err = p.Format("click", []byte{0x0a, 0x05, 'a'})
+	assert.Error(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = p.Format("scroll", []byte{0x0a, 0x05, 'a'})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
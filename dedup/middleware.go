@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// ErrInProgress is returned for a request whose original is still being collected. It wraps
+// collection.ErrUnavailable, the client should retry it.
+var ErrInProgress = fmt.Errorf("%w: request with the same ReqGuid is being collected", collection.ErrUnavailable)
+
+// Middleware drops the requests whose ReqGuid was already collected on the same connection within ttl. Duplicates
+// are acknowledged as the original was. Requests are collected anyway when the store fails.
+func Middleware(store Store, ttl time.Duration) collection.Middleware {
+	return func(next collection.Collector) collection.Collector {
+		return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+			if req.GetReqGuid() == "" {
+				return next.Collect(ctx, req)
+			}
+			key := req.ConnectionIdentifier.ID + "/" + req.GetReqGuid()
+			state, err := store.Reserve(key, ttl)
+			if err != nil {
+				logger.Errorf("[dedup] failed to check request %s of %s: %v", req.GetReqGuid(), req.ConnectionIdentifier, err)
+				metrics.Increment("dedup_errors_total", fmt.Sprintf("conn_group=%s", req.ConnectionIdentifier.Group))
+				return next.Collect(ctx, req)
+			}
+			switch state {
+			case Done:
+				metrics.Increment("batches_deduplicated_total", fmt.Sprintf("conn_group=%s", req.ConnectionIdentifier.Group))
+				return nil
+			case InProgress:
+				return ErrInProgress
+			}
+			if err := next.Collect(ctx, req); err != nil {
+				if rerr := store.Release(key); rerr != nil {
+					logger.Errorf("[dedup] failed to release request %s of %s: %v", req.GetReqGuid(), req.ConnectionIdentifier, rerr)
+				}
+				return err
+			}
+			if err := store.Done(key, ttl); err != nil {
+				logger.Errorf("[dedup] failed to mark request %s of %s done: %v", req.GetReqGuid(), req.ConnectionIdentifier, err)
+				metrics.Increment("dedup_errors_total", fmt.Sprintf("conn_group=%s", req.ConnectionIdentifier.Group))
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/logger"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type void struct{}
+
+func (v void) Write(_ []byte) (int, error) {
+	return 0, nil
+}
+
+type failingStore struct{}
+
+func (failingStore) Reserve(string, time.Duration) (State, error) {
+	return Absent, errors.New("unreachable")
+}
+
+func (failingStore) Done(string, time.Duration) error {
+	return errors.New("unreachable")
+}
+
+func (failingStore) Release(string) error {
+	return errors.New("unreachable")
+}
+
+func requestOf(connID, reqGUID string) *collection.CollectRequest {
+	return &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: connID, Group: "viewer"},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: reqGUID, Events: []*pb.Event{{Type: "click"}}},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	logger.SetOutput(void{})
+	ctx := context.Background()
+
+	t.Run("Should acknowledge the duplicates of a connection without collecting them", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		dedup := Middleware(NewMemoryStore(), time.Minute)(c)
+
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "abc")))
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "abc")))
+		c.AssertNumberOfCalls(t, "Collect", 1)
+
+		assert.NoError(t, dedup.Collect(ctx, requestOf("2", "abc")))
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "")))
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "")))
+		c.AssertNumberOfCalls(t, "Collect", 4)
+	})
+
+	t.Run("Should collect the retry of a request which failed", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(collection.ErrBufferFull).Once()
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		dedup := Middleware(NewMemoryStore(), time.Minute)(c)
+
+		assert.Equal(t, collection.ErrBufferFull, dedup.Collect(ctx, requestOf("1", "abc")))
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "abc")))
+		c.AssertNumberOfCalls(t, "Collect", 2)
+	})
+
+	t.Run("Should reject a duplicate while the original is being collected", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Reserve("1/abc", time.Minute)
+		c := &collection.MockCollector{}
+		assert.Equal(t, ErrInProgress, Middleware(store, time.Minute)(c).Collect(ctx, requestOf("1", "abc")))
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should collect the requests when the store fails", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		dedup := Middleware(failingStore{}, time.Minute)(c)
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "abc")))
+		assert.NoError(t, dedup.Collect(ctx, requestOf("1", "abc")))
+		c.AssertNumberOfCalls(t, "Collect", 2)
+	})
+}
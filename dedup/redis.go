@@ -0,0 +1,64 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	keyPrefix  = "raccoon:dedup:"
+	inProgress = "in_progress"
+	done       = "done"
+)
+
+// redisStore is a Store shared by every server of the fleet, backed by redis.
+type redisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store keeping the keys in the redis server at addr. Up to poolSize connections are kept
+// open. Every command, including dialing, is bounded by timeout and not retried.
+func NewRedisStore(addr, password string, poolSize int, timeout time.Duration) Store {
+	return &redisStore{client: redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		PoolSize:     poolSize,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		MaxRetries:   -1,
+	})}
+}
+
+func (s *redisStore) Reserve(key string, ttl time.Duration) (State, error) {
+	ctx := context.Background()
+	reserved, err := s.client.SetNX(ctx, keyPrefix+key, inProgress, ttl).Result()
+	if err != nil {
+		return Absent, err
+	}
+	if reserved {
+		return Absent, nil
+	}
+	state, err := s.client.Get(ctx, keyPrefix+key).Result()
+	if err == redis.Nil {
+		// the key expired in between, it is reported in progress and the client retries
+		return InProgress, nil
+	}
+	if err != nil {
+		return Absent, err
+	}
+	if state == done {
+		return Done, nil
+	}
+	return InProgress, nil
+}
+
+func (s *redisStore) Done(key string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), keyPrefix+key, done, ttl).Err()
+}
+
+func (s *redisStore) Release(key string) error {
+	return s.client.Del(context.Background(), keyPrefix+key).Err()
+}
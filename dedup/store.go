@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a request in the store.
+type State int
+
+const (
+	// Absent requests were not seen within the window
+	Absent State = iota
+	// InProgress requests are being collected
+	InProgress
+	// Done requests were collected
+	Done
+)
+
+// Store remembers the requests seen within a window.
+type Store interface {
+	// Reserve marks key in progress for ttl unless it is already known. Returns the state key was in, Absent meaning
+	// it is now reserved.
+	Reserve(key string, ttl time.Duration) (State, error)
+	// Done marks key done for ttl.
+	Done(key string, ttl time.Duration) error
+	// Release forgets key.
+	Release(key string) error
+}
+
+type entry struct {
+	state  State
+	expiry time.Time
+}
+
+// memoryStore is a Store local to the server.
+type memoryStore struct {
+	m         sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates a store keeping the keys in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]entry), lastSweep: time.Now()}
+}
+
+func (s *memoryStore) Reserve(key string, ttl time.Duration) (State, error) {
+	now := time.Now()
+	s.m.Lock()
+	defer s.m.Unlock()
+	// expired keys are removed once per window
+	if now.Sub(s.lastSweep) >= ttl {
+		for k, e := range s.entries {
+			if !e.expiry.After(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[key]; ok && e.expiry.After(now) {
+		return e.state, nil
+	}
+	s.entries[key] = entry{state: InProgress, expiry: now.Add(ttl)}
+	return Absent, nil
+}
+
+func (s *memoryStore) Done(key string, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.entries[key] = entry{state: Done, expiry: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) Release(key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,81 @@
+package dedup
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startRedis(t *testing.T, password string) *miniredis.Miniredis {
+	r, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(r.Close)
+	if password != "" {
+		r.RequireAuth(password)
+	}
+	return r
+}
+
+func TestStores(t *testing.T) {
+	// stores return the store and how to let time pass for its keys
+	stores := map[string]func(t *testing.T) (Store, func(time.Duration)){
+		"memory": func(*testing.T) (Store, func(time.Duration)) { return NewMemoryStore(), time.Sleep },
+		"redis": func(t *testing.T) (Store, func(time.Duration)) {
+			r := startRedis(t, "")
+			return NewRedisStore(r.Addr(), "", 2, time.Second), r.FastForward
+		},
+		"redis with password": func(t *testing.T) (Store, func(time.Duration)) {
+			r := startRedis(t, "secret")
+			return NewRedisStore(r.Addr(), "secret", 2, time.Second), r.FastForward
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			s, wait := newStore(t)
+			state, err := s.Reserve("1/abc", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, Absent, state)
+
+			state, err = s.Reserve("1/abc", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, InProgress, state)
+
+			require.NoError(t, s.Done("1/abc", time.Minute))
+			state, err = s.Reserve("1/abc", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, Done, state)
+
+			require.NoError(t, s.Release("1/abc"))
+			state, err = s.Reserve("1/abc", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, Absent, state)
+
+			require.NoError(t, s.Done("2/abc", 20*time.Millisecond))
+			wait(30 * time.Millisecond)
+			state, err = s.Reserve("2/abc", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, Absent, state)
+		})
+	}
+}
+
+func TestRedisStore_Errors(t *testing.T) {
+	t.Run("Should fail with a wrong password", func(t *testing.T) {
+		s := NewRedisStore(startRedis(t, "secret").Addr(), "wrong", 2, time.Second)
+		_, err := s.Reserve("1/abc", time.Minute)
+		assert.Error(t, err)
+	})
+
+	t.Run("Should fail when redis is unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+		_, err = NewRedisStore(addr, "", 2, 100*time.Millisecond).Reserve("1/abc", time.Minute)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,132 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// The headers the events are enriched with, the ones which are unknown being left out.
+const (
+	HeaderClientIP = "raccoon-client-ip"
+	HeaderBrowser  = "raccoon-ua-browser"
+	HeaderOS       = "raccoon-ua-os"
+	HeaderDevice   = "raccoon-ua-device"
+	HeaderCountry  = "raccoon-geo-country"
+	HeaderCity     = "raccoon-geo-city"
+)
+
+// Enricher is a collector stage adding the IP address, the user agent and the location of the client to the
+// enrichment of the requests.
+type Enricher struct {
+	trustedProxies []*net.IPNet
+	geo            *GeoDB
+}
+
+// New creates an enricher trusting the X-Forwarded-For header set by the proxies in trustedProxies, as IP addresses
+// or CIDR ranges. The location is looked up in geo, the location is not added when geo is nil.
+func New(trustedProxies []string, geo *GeoDB) (*Enricher, error) {
+	e := &Enricher{geo: geo}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		e.trustedProxies = append(e.trustedProxies, network)
+	}
+	return e, nil
+}
+
+func (e *Enricher) trusted(ip net.IP) bool {
+	for _, network := range e.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is followed from the peer backwards as long as the
+// addresses are trusted proxies, the first untrusted address being the client. Returns nil when unknown.
+func (e *Enricher) ClientIP(client identification.Client) net.IP {
+	host, _, err := net.SplitHostPort(client.RemoteAddr)
+	if err != nil {
+		host = client.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !e.trusted(ip) || client.ForwardedFor == "" {
+		return ip
+	}
+	forwarded := strings.Split(client.ForwardedFor, ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// the header was not written by a trusted proxy from there
+			return ip
+		}
+		ip = hop
+		if !e.trusted(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// enrichment returns the headers describing the client.
+func (e *Enricher) enrichment(client identification.Client, group string) map[string]string {
+	headers := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			headers[key] = value
+		}
+	}
+	ip := e.ClientIP(client)
+	if ip != nil {
+		set(HeaderClientIP, ip.String())
+	}
+	ua := ParseUserAgent(client.UserAgent)
+	set(HeaderBrowser, ua.Browser)
+	set(HeaderOS, ua.OS)
+	set(HeaderDevice, ua.Device)
+	if e.geo != nil && ip != nil {
+		location, err := e.geo.Lookup(ip)
+		if err != nil {
+			logger.Errorf("[enrichment] failed to look %s up: %v", ip, err)
+			metrics.Increment("enrichment_errors_total", "conn_group="+group)
+		}
+		set(HeaderCountry, location.Country)
+		set(HeaderCity, location.City)
+	}
+	return headers
+}
+
+// Middleware adds the client context to the enrichment of every request.
+func (e *Enricher) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		headers := e.enrichment(req.Client, group)
+		if len(headers) > 0 {
+			if req.Enrichment == nil {
+				req.Enrichment = make(map[string]string, len(headers))
+			}
+			for key, value := range headers {
+				req.Enrichment[key] = value
+			}
+		}
+		metrics.Increment("batches_enriched_total", fmt.Sprintf("conn_group=%s,geo=%t", group, headers[HeaderCountry] != ""))
+		return next.Collect(ctx, req)
+	})
+}
@@ -0,0 +1,77 @@
+package enrichment
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnricher_ClientIP(t *testing.T) {
+	e, err := New([]string{"10.0.0.0/8", "192.168.1.1"}, nil)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		client   identification.Client
+		expected string
+	}{
+		{identification.Client{RemoteAddr: "1.2.3.4:5000"}, "1.2.3.4"},
+		{identification.Client{RemoteAddr: "1.2.3.4:5000", ForwardedFor: "5.6.7.8"}, "1.2.3.4"},
+		{identification.Client{RemoteAddr: "10.1.1.1:5000", ForwardedFor: "5.6.7.8"}, "5.6.7.8"},
+		{identification.Client{RemoteAddr: "10.1.1.1:5000", ForwardedFor: "9.9.9.9, 5.6.7.8, 192.168.1.1"}, "5.6.7.8"},
+		{identification.Client{RemoteAddr: "10.1.1.1:5000", ForwardedFor: "10.2.2.2, 192.168.1.1"}, "10.2.2.2"},
+		{identification.Client{RemoteAddr: "10.1.1.1:5000", ForwardedFor: "5.6.7.8, unknown"}, "10.1.1.1"},
+		{identification.Client{RemoteAddr: "10.1.1.1:5000"}, "10.1.1.1"},
+		{identification.Client{RemoteAddr: "[2001:db8::1]:5000"}, "2001:db8::1"},
+	} {
+		assert.Equal(t, net.ParseIP(c.expected), e.ClientIP(c.client), "%+v", c.client)
+	}
+	assert.Nil(t, e.ClientIP(identification.Client{}))
+
+	_, err = New([]string{"10.0.0.0/33"}, nil)
+	assert.Error(t, err)
+	_, err = New([]string{"proxy"}, nil)
+	assert.Error(t, err)
+}
+
+func TestEnricher_Middleware(t *testing.T) {
+	geo, err := OpenGeoDB(testGeoDB(t))
+	require.NoError(t, err)
+	e, err := New([]string{"10.0.0.0/8"}, geo)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	req := &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: "viewer"},
+		Client: identification.Client{
+			RemoteAddr:   "10.1.1.1:5000",
+			ForwardedFor: "1.2.3.4",
+			UserAgent:    "Mozilla/5.0 (Linux; Android 12; Pixel 6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.104 Mobile Safari/537.36",
+		},
+		Enrichment:       map[string]string{"raccoon-region": "apac"},
+		SendEventRequest: &pb.SendEventRequest{ReqGuid: "abc"},
+	}
+	c := &collection.MockCollector{}
+	c.On("Collect", ctx, req).Return(nil)
+	assert.NoError(t, e.Middleware(c).Collect(ctx, req))
+	assert.Equal(t, map[string]string{
+		"raccoon-region": "apac",
+		HeaderClientIP:   "1.2.3.4",
+		HeaderBrowser:    "Chrome",
+		HeaderOS:         "Android",
+		HeaderDevice:     "mobile",
+		HeaderCountry:    "ID",
+		HeaderCity:       "Jakarta",
+	}, req.Enrichment)
+	c.AssertExpectations(t)
+
+	req = &collection.CollectRequest{Client: identification.Client{RemoteAddr: "5.6.7.8:5000"}, SendEventRequest: &pb.SendEventRequest{}}
+	c.On("Collect", ctx, req).Return(nil)
+	assert.NoError(t, e.Middleware(c).Collect(ctx, req))
+	assert.Equal(t, map[string]string{HeaderClientIP: "5.6.7.8"}, req.Enrichment)
+}
@@ -0,0 +1,57 @@
+package enrichment
+
+import (
+	"io/ioutil"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoDB looks the location of IP addresses up in a database in the MaxMind DB format, such as GeoLite2 City or
+// GeoIP2 Country.
+type GeoDB struct {
+	reader *maxminddb.Reader
+}
+
+// Location is where an IP address is, its fields being empty when unknown.
+type Location struct {
+	// Country is the ISO 3166-1 code of the country
+	Country string
+	// City is the English name of the city
+	City string
+}
+
+// geoRecord is the part of the records of the database a Location is read from.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// OpenGeoDB reads the database at path in memory.
+func OpenGeoDB(path string) (*GeoDB, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoDB{reader: reader}, nil
+}
+
+// Lookup returns the location of ip, the zero Location when it is not in the database.
+func (db *GeoDB) Lookup(ip net.IP) (Location, error) {
+	if ip.To4() == nil && db.reader.Metadata.IPVersion == 4 {
+		return Location{}, nil
+	}
+	var record geoRecord
+	if err := db.reader.Lookup(ip, &record); err != nil {
+		return Location{}, err
+	}
+	return Location{Country: record.Country.ISOCode, City: record.City.Names["en"]}, nil
+}
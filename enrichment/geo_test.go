@@ -0,0 +1,210 @@
+package enrichment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metadataMarker starts the metadata section at the end of the database.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// types of the data section of the MaxMind DB format
+const (
+	typePointer = 1
+	typeString  = 2
+	typeUint32  = 6
+	typeMap     = 7
+)
+
+// mmdb builds an IPv6 MaxMind database with 24 bit records.
+type mmdb struct {
+	// nodes holds the records of each node: 0 when empty, n > 0 for node n, -(offset+1) for data at offset
+	nodes [][2]int
+	data  bytes.Buffer
+}
+
+func newMMDB() *mmdb {
+	return &mmdb{nodes: make([][2]int, 1)}
+}
+
+func (db *mmdb) insert(cidr string, offset int) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ones, bits := network.Mask.Size()
+	ip := network.IP.To16()
+	if bits == 32 {
+		// IPv4 networks are in ::/96
+		ip = append(make(net.IP, 12), network.IP.To4()...)
+		ones += 96
+	}
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if i == ones-1 {
+			db.nodes[node][bit] = -(offset + 1)
+			break
+		}
+		if db.nodes[node][bit] <= 0 {
+			db.nodes = append(db.nodes, [2]int{})
+			db.nodes[node][bit] = len(db.nodes) - 1
+		}
+		node = db.nodes[node][bit]
+	}
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte(typeString<<5 | byte(len(s)))
+	buf.WriteString(s)
+}
+
+func encodeUint32(buf *bytes.Buffer, v uint32) {
+	buf.WriteByte(typeUint32<<5 | 4)
+	binary.Write(buf, binary.BigEndian, v)
+}
+
+// location appends the record of a location to the data section, returning its offset. The country map is a pointer
+// to countryAt when it is not negative.
+func (db *mmdb) location(country, city string, countryAt int) (offset int, countryOffset int) {
+	offset = db.data.Len()
+	db.data.WriteByte(typeMap<<5 | 2)
+	encodeString(&db.data, "country")
+	countryOffset = db.data.Len()
+	if countryAt >= 0 {
+		db.data.Write([]byte{typePointer<<5 | byte(countryAt>>8&0x7), byte(countryAt)})
+	} else {
+		db.data.WriteByte(typeMap<<5 | 1)
+		encodeString(&db.data, "iso_code")
+		encodeString(&db.data, country)
+	}
+	encodeString(&db.data, "city")
+	db.data.WriteByte(typeMap<<5 | 1)
+	encodeString(&db.data, "names")
+	db.data.WriteByte(typeMap<<5 | 1)
+	encodeString(&db.data, "en")
+	encodeString(&db.data, city)
+	return offset, countryOffset
+}
+
+func (db *mmdb) write(t *testing.T) string {
+	var buf bytes.Buffer
+	nodeCount := len(db.nodes)
+	for _, records := range db.nodes {
+		for _, r := range records {
+			v := nodeCount
+			if r > 0 {
+				v = r
+			} else if r < 0 {
+				v = nodeCount + 16 - r - 1
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(db.data.Bytes())
+	buf.Write(metadataMarker)
+	buf.WriteByte(typeMap<<5 | 3)
+	encodeString(&buf, "node_count")
+	encodeUint32(&buf, uint32(nodeCount))
+	encodeString(&buf, "record_size")
+	encodeUint32(&buf, 24)
+	encodeString(&buf, "ip_version")
+	encodeUint32(&buf, 6)
+
+	dir, err := ioutil.TempDir("", "enrichment")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "geo.mmdb")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+// testGeoDB maps 1.2.3.0/24 to Jakarta and 2001:db8::/32 to Bandung, both in Indonesia.
+func testGeoDB(t *testing.T) string {
+	db := newMMDB()
+	jakarta, country := db.location("ID", "Jakarta", -1)
+	bandung, _ := db.location("", "Bandung", country)
+	db.insert("1.2.3.0/24", jakarta)
+	db.insert("2001:db8::/32", bandung)
+	return db.write(t)
+}
+
+func TestGeoDB_Lookup(t *testing.T) {
+	db, err := OpenGeoDB(testGeoDB(t))
+	require.NoError(t, err)
+
+	for ip, expected := range map[string]Location{
+		"1.2.3.4":        {Country: "ID", City: "Jakarta"},
+		"1.2.3.255":      {Country: "ID", City: "Jakarta"},
+		"2001:db8::1":    {Country: "ID", City: "Bandung"},
+		"1.2.4.1":        {},
+		"2001:db9::1":    {},
+		"::ffff:1.2.3.4": {Country: "ID", City: "Jakarta"},
+	} {
+		location, err := db.Lookup(net.ParseIP(ip))
+		assert.NoError(t, err, ip)
+		assert.Equal(t, expected, location, ip)
+	}
+}
+
+func TestOpenGeoDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrichment")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "geo.mmdb")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a database"), 0644))
+
+	_, err = OpenGeoDB(path)
+	assert.Error(t, err)
+	_, err = OpenGeoDB(filepath.Join(dir, "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+func TestGeoDB_LookupCorrupt(t *testing.T) {
+	valid, err := ioutil.ReadFile(testGeoDB(t))
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "enrichment")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "corrupt.mmdb")
+
+	// every byte overwritten in turn, the database either fails to open or to look up, without panicking
+	for i := range valid {
+		for _, b := range []byte{0x00, 0x20, 0xFF} {
+			corrupt := append([]byte(nil), valid...)
+			corrupt[i] = b
+			require.NoError(t, ioutil.WriteFile(path, corrupt, 0644))
+			assert.NotPanics(t, func() {
+				db, err := OpenGeoDB(path)
+				if err != nil {
+					return
+				}
+				for _, ip := range []string{"1.2.3.4", "2001:db8::1", "1.2.4.1"} {
+					db.Lookup(net.ParseIP(ip))
+				}
+			}, "byte %d set to %x", i, b)
+		}
+	}
+	_, err = OpenGeoDB(testGeoDBTruncated(t, valid))
+	assert.Error(t, err)
+}
+
+// testGeoDBTruncated writes the database without the end of its search tree.
+func testGeoDBTruncated(t *testing.T, valid []byte) string {
+	i := bytes.LastIndex(valid, metadataMarker)
+	dir, err := ioutil.TempDir("", "enrichment")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "truncated.mmdb")
+	require.NoError(t, ioutil.WriteFile(path, append(append([]byte(nil), valid[:6]...), valid[i:]...), 0644))
+	return path
+}
@@ -0,0 +1,80 @@
+package enrichment
+
+import "strings"
+
+// UserAgent is what a User-Agent header tells of the client, its fields being empty when unknown.
+type UserAgent struct {
+	// Browser is the name of the browser, such as Chrome or Safari
+	Browser string
+	// OS is the name of the operating system, such as Android or iOS
+	OS string
+	// Device is one of bot, mobile, tablet and desktop
+	Device string
+}
+
+// rule maps the user agents containing any of tokens to name.
+type rule struct {
+	tokens []string
+	name   string
+}
+
+// the rules are matched in order, the first matching one wins
+var (
+	browserRules = []rule{
+		{[]string{"Edg/", "Edge/", "EdgA/", "EdgiOS/"}, "Edge"},
+		{[]string{"OPR/", "Opera"}, "Opera"},
+		{[]string{"SamsungBrowser/"}, "Samsung Internet"},
+		{[]string{"Firefox/", "FxiOS/"}, "Firefox"},
+		{[]string{"Chrome/", "CriOS/", "Chromium/"}, "Chrome"},
+		{[]string{"Safari/"}, "Safari"},
+		{[]string{"MSIE ", "Trident/"}, "Internet Explorer"},
+	}
+	osRules = []rule{
+		{[]string{"Windows"}, "Windows"},
+		{[]string{"iPhone", "iPad", "iPod"}, "iOS"},
+		{[]string{"Android"}, "Android"},
+		{[]string{"CrOS"}, "Chrome OS"},
+		{[]string{"Mac OS X", "Macintosh"}, "macOS"},
+		{[]string{"Linux"}, "Linux"},
+	}
+	deviceRules = []rule{
+		{[]string{"bot", "Bot", "crawler", "spider", "Spider"}, "bot"},
+		{[]string{"iPad", "Tablet"}, "tablet"},
+		{[]string{"Mobi", "iPhone", "iPod"}, "mobile"},
+	}
+)
+
+func match(ua string, rules []rule) string {
+	for _, r := range rules {
+		for _, token := range r.tokens {
+			if strings.Contains(ua, token) {
+				return r.name
+			}
+		}
+	}
+	return ""
+}
+
+// ParseUserAgent returns what the User-Agent header ua tells of the client. It is a best-effort guess from well-known
+// tokens of the major browsers and operating systems, not a full parser: spoofed, unusual or future user agents may be
+// reported wrong or left unknown.
+func ParseUserAgent(ua string) UserAgent {
+	if ua == "" {
+		return UserAgent{}
+	}
+	parsed := UserAgent{
+		Browser: match(ua, browserRules),
+		OS:      match(ua, osRules),
+		Device:  match(ua, deviceRules),
+	}
+	if parsed.Device == "" {
+		switch parsed.OS {
+		case "Android":
+			// Android phones tell Mobile, tablets do not
+			parsed.Device = "tablet"
+		case "Windows", "macOS", "Chrome OS", "Linux":
+			parsed.Device = "desktop"
+		}
+	}
+	return parsed
+}
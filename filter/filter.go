@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+)
+
+// Action tells what to do with the events of a type which is not accepted.
+type Action string
+
+const (
+	// ActionDrop removes the events from their batch, the rest of the batch is collected.
+	ActionDrop Action = "drop"
+	// ActionReject fails the whole batch with an error wrapping collection.ErrInvalidRequest.
+	ActionReject Action = "reject"
+	// ActionQuarantine publishes the events with the quarantine type, so that they go to its topic, their records
+	// carrying collection.HeaderOriginalType.
+	ActionQuarantine Action = "quarantine"
+)
+
+// AllGroups is the group of the rules applying to every connection group.
+const AllGroups = "*"
+
+// Rules are the event types accepted from each connection group, keyed by group or AllGroups.
+type Rules struct {
+	// Allow lists the only accepted types, every type is accepted when a group has no allowlist
+	Allow map[string][]string
+	// Deny lists the types which are not accepted
+	Deny map[string][]string
+}
+
+// Filter is a collector stage filtering the events by type. Besides the static rules, types can be disabled at
+// runtime as a kill switch.
+type Filter struct {
+	allow          map[string]map[string]struct{}
+	deny           map[string]map[string]struct{}
+	action         Action
+	quarantineType string
+
+	m sync.RWMutex
+	// disabled maps the types disabled at runtime to their action
+	disabled map[string]Action
+}
+
+// New creates a filter applying action to the events not accepted by the rules. Quarantined events are published
+// with quarantineType.
+func New(rules Rules, action Action, quarantineType string) *Filter {
+	return &Filter{
+		allow:          toSets(rules.Allow),
+		deny:           toSets(rules.Deny),
+		action:         action,
+		quarantineType: quarantineType,
+		disabled:       make(map[string]Action),
+	}
+}
+
+func toSets(rules map[string][]string) map[string]map[string]struct{} {
+	sets := make(map[string]map[string]struct{}, len(rules))
+	for group, types := range rules {
+		set := make(map[string]struct{}, len(types))
+		for _, t := range types {
+			set[t] = struct{}{}
+		}
+		sets[group] = set
+	}
+	return sets
+}
+
+// Disable stops accepting the events of eventType from every group, applying action to them. The action of the
+// filter applies when action is empty.
+func (f *Filter) Disable(eventType string, action Action) {
+	if action == "" {
+		action = f.action
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.disabled[eventType] = action
+	logger.Info(fmt.Sprintf("[filter] disabled event type %s, action %s", eventType, action))
+}
+
+// Enable accepts again the events of eventType after Disable.
+func (f *Filter) Enable(eventType string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.disabled, eventType)
+	logger.Info(fmt.Sprintf("[filter] enabled event type %s", eventType))
+}
+
+// Disabled returns the types disabled at runtime along with their action.
+func (f *Filter) Disabled() map[string]Action {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	disabled := make(map[string]Action, len(f.disabled))
+	for t, action := range f.disabled {
+		disabled[t] = action
+	}
+	return disabled
+}
+
+// actionOf returns the action applying to the events of eventType from group, false when they are accepted.
+func (f *Filter) actionOf(group, eventType string) (Action, bool) {
+	f.m.RLock()
+	action, disabled := f.disabled[eventType]
+	f.m.RUnlock()
+	if disabled {
+		return action, true
+	}
+	for _, g := range []string{group, AllGroups} {
+		if _, ok := f.deny[g][eventType]; ok {
+			return f.action, true
+		}
+		if allow, ok := f.allow[g]; ok {
+			if _, ok := allow[eventType]; !ok {
+				return f.action, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Middleware applies the filter to the events of every request.
+func (f *Filter) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		var events []*pb.Event
+		filtered := false
+		for _, e := range req.GetEvents() {
+			action, ok := f.actionOf(group, e.Type)
+			if !ok {
+				events = append(events, e)
+				continue
+			}
+			filtered = true
+			metrics.Increment("events_filtered_total", fmt.Sprintf("action=%s,conn_group=%s,event_type=%s", action, group, e.Type))
+			switch action {
+			case ActionReject:
+				return fmt.Errorf("%w: event type %s is not accepted", collection.ErrInvalidRequest, e.Type)
+			case ActionQuarantine:
+				events = append(events, req.Retype(e, f.quarantineType))
+			}
+		}
+		if !filtered {
+			return next.Collect(ctx, req)
+		}
+		if len(events) == 0 {
+			// every event was dropped
+			return nil
+		}
+		req.Events = events
+		return next.Collect(ctx, req)
+	})
+}
@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/logger"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type void struct{}
+
+func (v void) Write(_ []byte) (int, error) {
+	return 0, nil
+}
+
+func requestOf(group string, types ...string) *collection.CollectRequest {
+	req := &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: group},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc"},
+	}
+	for _, t := range types {
+		req.Events = append(req.Events, &pb.Event{EventBytes: []byte(t), Type: t})
+	}
+	return req
+}
+
+// collected returns the types of the events collected by c.
+func collected(c *collection.MockCollector) [][]string {
+	var batches [][]string
+	for _, call := range c.Calls {
+		var types []string
+		for _, e := range call.Arguments.Get(1).(*collection.CollectRequest).Events {
+			types = append(types, e.Type)
+		}
+		batches = append(batches, types)
+	}
+	return batches
+}
+
+func TestFilter(t *testing.T) {
+	logger.SetOutput(void{})
+	ctx := context.Background()
+	rules := Rules{
+		Allow: map[string][]string{"driver": {"location", "booking"}},
+		Deny:  map[string][]string{AllGroups: {"debug"}, "viewer": {"scroll"}},
+	}
+
+	t.Run("Should drop the events not accepted", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		f := New(rules, ActionDrop, "quarantine").Middleware(c)
+
+		assert.NoError(t, f.Collect(ctx, requestOf("viewer", "click", "scroll", "debug")))
+		assert.NoError(t, f.Collect(ctx, requestOf("driver", "location", "click")))
+		assert.NoError(t, f.Collect(ctx, requestOf("rider", "scroll")))
+		assert.NoError(t, f.Collect(ctx, requestOf("rider", "debug")))
+		assert.Equal(t, [][]string{{"click"}, {"location"}, {"scroll"}}, collected(c))
+	})
+
+	t.Run("Should reject the batches holding events not accepted", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		f := New(rules, ActionReject, "quarantine").Middleware(c)
+
+		err := f.Collect(ctx, requestOf("viewer", "click", "debug"))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+		assert.EqualError(t, err, "invalid request: event type debug is not accepted")
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should quarantine the events not accepted", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		f := New(rules, ActionQuarantine, "quarantine").Middleware(c)
+
+		req := requestOf("viewer", "click", "debug")
+		assert.NoError(t, f.Collect(ctx, req))
+		assert.Equal(t, [][]string{{"click", "quarantine"}}, collected(c))
+		assert.Equal(t, []byte("debug"), req.Events[1].EventBytes)
+		assert.Nil(t, req.Headers(req.Events[0]))
+		assert.Equal(t, map[string]string{collection.HeaderOriginalType: "debug"}, req.Headers(req.Events[1]))
+	})
+
+	t.Run("Should apply the types disabled at runtime", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		filter := New(Rules{}, ActionDrop, "quarantine")
+		f := filter.Middleware(c)
+
+		filter.Disable("click", "")
+		filter.Disable("scroll", ActionReject)
+		assert.Equal(t, map[string]Action{"click": ActionDrop, "scroll": ActionReject}, filter.Disabled())
+		assert.NoError(t, f.Collect(ctx, requestOf("viewer", "click", "booking")))
+		assert.Error(t, f.Collect(ctx, requestOf("viewer", "scroll")))
+
+		filter.Enable("click")
+		filter.Enable("scroll")
+		assert.Empty(t, filter.Disabled())
+		assert.NoError(t, f.Collect(ctx, requestOf("viewer", "click", "scroll")))
+		assert.Equal(t, [][]string{{"booking"}, {"click", "scroll"}}, collected(c))
+	})
+}
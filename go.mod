@@ -3,13 +3,17 @@ module github.com/odpf/raccoon
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.31.1
 	github.com/confluentinc/confluent-kafka-go v1.4.2 // indirect
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/websocket v1.4.2
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/sys v0.0.0-20211109184856-51b60fd695b3 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.41.0
 	google.golang.org/protobuf v1.28.0
 	gopkg.in/alexcesaro/statsd.v2 v2.0.0
@@ -0,0 +1,24 @@
+package identification
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Client is what the server knows of the client sending a request, as seen at the edge.
+type Client struct {
+	// RemoteAddr is the address of the peer, a proxy when the request was forwarded
+	RemoteAddr string
+	// ForwardedFor is the X-Forwarded-For header of the request, empty when it was not forwarded
+	ForwardedFor string
+	UserAgent    string
+}
+
+// NewClient returns the client of an HTTP request.
+func NewClient(r *http.Request) Client {
+	return Client{
+		RemoteAddr:   r.RemoteAddr,
+		ForwardedFor: strings.Join(r.Header.Values("X-Forwarded-For"), ", "),
+		UserAgent:    r.UserAgent(),
+	}
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/odpf/raccoon/config"
 	"github.com/odpf/raccoon/logger"
@@ -107,3 +108,15 @@ func SetVoid() {
 		c: c,
 	}
 }
+
+// SetAddress sends the metrics to the statsd server at addr, flushing them every flushPeriod.
+func SetAddress(addr string, flushPeriod time.Duration) error {
+	c, err := client.New(client.Address(addr), client.FlushPeriod(flushPeriod))
+	if err != nil {
+		return err
+	}
+	instance = &Statsd{
+		c: c,
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
+)
+
+// AggregatedEventsHeader is set on records packing several events, its value is the number of events in the record.
+// The value of such a record is a serialized SendEventRequest whose Events are the aggregated events.
+const AggregatedEventsHeader = "raccoon-aggregated-events"
+
+// AggregatorConfig bounds the records the aggregator builds.
+type AggregatorConfig struct {
+	// EventTypes are the event types to aggregate, other event types are published one event per record.
+	EventTypes []string
+	// MaxEvents is the maximum number of events packed in a record.
+	MaxEvents int
+	// MaxBytes is the maximum serialized size of the events packed in a record.
+	MaxBytes int
+	// Linger is the maximum time an event waits for other events before its record is produced.
+	Linger time.Duration
+}
+
+// errAggregatorClosed is returned for the events added once the producer is closing.
+var errAggregatorClosed = errors.New("aggregator is closed")
+
+type aggregateKey struct {
+	eventType string
+	connGroup string
+}
+
+// aggregatedRecord is the opaque of aggregated records, handed back in their delivery report.
+type aggregatedRecord struct {
+	aggregateKey
+	count int
+	// id identifies the record among the in flight ones
+	id uint64
+}
+
+type aggregate struct {
+	events []*pb.Event
+	bytes  int
+	timer  *time.Timer
+}
+
+// aggregator packs events of the same type and connection group into single Kafka records.
+// Events are acknowledged once buffered, delivery failures of the records are reported as metrics only.
+type aggregator struct {
+	config      AggregatorConfig
+	eventTypes  map[string]struct{}
+	topicFormat string
+	inFlight    *inFlight
+	produce     func(eventType string, message *kafka.Message, deliveryChannel chan kafka.Event) error
+
+	m          sync.Mutex
+	aggregates map[aggregateKey]*aggregate
+	// stopped is set once the pending aggregates were produced for closing, events are no longer added
+	stopped bool
+	delivery   chan kafka.Event
+	done       chan struct{}
+}
+
+func newAggregator(config AggregatorConfig, topicFormat string, inFlight *inFlight, produce func(string, *kafka.Message, chan kafka.Event) error) *aggregator {
+	eventTypes := make(map[string]struct{})
+	for _, t := range config.EventTypes {
+		eventTypes[t] = struct{}{}
+	}
+	a := &aggregator{
+		config:      config,
+		eventTypes:  eventTypes,
+		topicFormat: topicFormat,
+		inFlight:    inFlight,
+		produce:     produce,
+		aggregates:  make(map[aggregateKey]*aggregate),
+		delivery:    make(chan kafka.Event, 100),
+		done:        make(chan struct{}),
+	}
+	go a.reportDelivery()
+	return a
+}
+
+func (a *aggregator) accepts(eventType string) bool {
+	_, ok := a.eventTypes[eventType]
+	return ok
+}
+
+// add buffers the event, producing the record of its type and group once it is full. Returns errAggregatorClosed once
+// stopped.
+func (a *aggregator) add(event *pb.Event, connGroup string) error {
+	key := aggregateKey{eventType: event.Type, connGroup: connGroup}
+	size := proto.Size(event) + 5 // field tag and length prefix of the event within the container
+	var full []*pb.Event
+
+	a.m.Lock()
+	if a.stopped {
+		a.m.Unlock()
+		return errAggregatorClosed
+	}
+	agg, ok := a.aggregates[key]
+	if ok && agg.bytes+size > a.config.MaxBytes {
+		full = a.detach(key, agg)
+		ok = false
+	}
+	if !ok {
+		agg = &aggregate{}
+		a.aggregates[key] = agg
+		agg.timer = time.AfterFunc(a.config.Linger, func() { a.flush(key, agg) })
+	}
+	agg.events = append(agg.events, event)
+	agg.bytes += size
+	var filled []*pb.Event
+	if len(agg.events) >= a.config.MaxEvents || agg.bytes >= a.config.MaxBytes {
+		filled = a.detach(key, agg)
+	}
+	a.m.Unlock()
+
+	a.produceRecord(key, full)
+	a.produceRecord(key, filled)
+	return nil
+}
+
+// flush produces the aggregate if it is still pending.
+func (a *aggregator) flush(key aggregateKey, agg *aggregate) {
+	a.m.Lock()
+	var events []*pb.Event
+	if a.aggregates[key] == agg {
+		events = a.detach(key, agg)
+	}
+	a.m.Unlock()
+	a.produceRecord(key, events)
+}
+
+// detach removes the aggregate from the pending ones. Must be called with the lock held.
+func (a *aggregator) detach(key aggregateKey, agg *aggregate) []*pb.Event {
+	agg.timer.Stop()
+	delete(a.aggregates, key)
+	return agg.events
+}
+
+func (a *aggregator) produceRecord(key aggregateKey, events []*pb.Event) {
+	if len(events) == 0 {
+		return
+	}
+	tags := fmt.Sprintf("conn_group=%s,event_type=%s", key.connGroup, key.eventType)
+	value, err := proto.Marshal(&pb.SendEventRequest{SentTime: timestamppb.Now(), Events: events})
+	if err != nil {
+		logger.Errorf("[aggregator] failed to pack %d events of %s: %v", len(events), key.eventType, err)
+		metrics.Count("kafka_messages_delivered_total", len(events), "success=false,"+tags)
+		return
+	}
+	topic := fmt.Sprintf(a.topicFormat, key.eventType)
+	message := &kafka.Message{
+		Value:          value,
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Headers:        []kafka.Header{{Key: AggregatedEventsHeader, Value: []byte(strconv.Itoa(len(events)))}},
+		Opaque:         aggregatedRecord{aggregateKey: key, count: len(events), id: a.inFlight.add(key.connGroup, nil, events...)},
+	}
+	metrics.Increment("kafka_aggregated_records_total", tags)
+	if err := a.produce(key.eventType, message, a.delivery); err != nil {
+		a.inFlight.done(message.Opaque.(aggregatedRecord).id)
+		logger.Errorf("[aggregator] failed to produce %d events of %s: %v", len(events), key.eventType, err)
+		metrics.Count("kafka_messages_delivered_total", len(events), "success=false,"+tags)
+	}
+}
+
+func (a *aggregator) reportDelivery() {
+	defer close(a.done)
+	for e := range a.delivery {
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			continue
+		}
+		record := m.Opaque.(aggregatedRecord)
+		a.inFlight.done(record.id)
+		tags := fmt.Sprintf("conn_group=%s,event_type=%s", record.connGroup, record.eventType)
+		if m.TopicPartition.Error != nil {
+			logger.Errorf("[aggregator] failed to deliver %d events of %s: %v", record.count, record.eventType, m.TopicPartition.Error)
+			metrics.Count("kafka_messages_delivered_total", record.count, "success=false,"+tags)
+			if isUnknownTopic(m.TopicPartition.Error) {
+				metrics.Increment("kafka_unknown_topic_failure_total", fmt.Sprintf("topic=%s,%s", *m.TopicPartition.Topic, tags))
+			}
+			continue
+		}
+		metrics.Count("kafka_messages_delivered_total", record.count, "success=true,"+tags)
+	}
+}
+
+// flushAll produces every pending aggregate regardless of the limits.
+func (a *aggregator) flushAll() {
+	a.m.Lock()
+	pending := make(map[aggregateKey][]*pb.Event)
+	for key, agg := range a.aggregates {
+		pending[key] = a.detach(key, agg)
+	}
+	a.m.Unlock()
+	for key, events := range pending {
+		a.produceRecord(key, events)
+	}
+}
+
+// stop refuses the events added from now on and produces every pending aggregate, before the producers are flushed.
+func (a *aggregator) stop() {
+	a.m.Lock()
+	a.stopped = true
+	a.m.Unlock()
+	a.flushAll()
+}
+
+// close stops reporting deliveries. The producers must be flushed and closed beforehand.
+func (a *aggregator) close() {
+	close(a.delivery)
+	<-a.done
+}
@@ -0,0 +1,117 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
+)
+
+func newCapturingAggregator(cfg AggregatorConfig) (*aggregator, chan *kafka.Message) {
+	produced := make(chan *kafka.Message, 10)
+	a := newAggregator(cfg, "clickstream-%s-log", &inFlight{}, func(eventType string, message *kafka.Message, deliveryChannel chan kafka.Event) error {
+		produced <- message
+		return nil
+	})
+	return a, produced
+}
+
+func unpack(t *testing.T, m *kafka.Message) []*pb.Event {
+	container := &pb.SendEventRequest{}
+	require.NoError(t, proto.Unmarshal(m.Value, container))
+	return container.Events
+}
+
+func TestAggregator(t *testing.T) {
+	event := &pb.Event{EventBytes: []byte("scrolled"), Type: "scroll"}
+
+	t.Run("Should produce one record when max events is reached", func(t *testing.T) {
+		a, produced := newCapturingAggregator(AggregatorConfig{MaxEvents: 3, MaxBytes: 1024, Linger: time.Hour})
+		for i := 0; i < 3; i++ {
+			a.add(event, group1)
+		}
+
+		m := <-produced
+		assert.Equal(t, "clickstream-scroll-log", *m.TopicPartition.Topic)
+		assert.Equal(t, []kafka.Header{{Key: AggregatedEventsHeader, Value: []byte("3")}}, m.Headers)
+		assert.Len(t, unpack(t, m), 3)
+		assert.Empty(t, produced)
+	})
+
+	t.Run("Should produce pending events after linger", func(t *testing.T) {
+		a, produced := newCapturingAggregator(AggregatorConfig{MaxEvents: 100, MaxBytes: 1024, Linger: 10 * time.Millisecond})
+		a.add(event, group1)
+
+		select {
+		case m := <-produced:
+			assert.Len(t, unpack(t, m), 1)
+		case <-time.After(time.Second):
+			assert.Fail(t, "record not produced after linger")
+		}
+	})
+
+	t.Run("Should not exceed max bytes", func(t *testing.T) {
+		size := proto.Size(event) + 5
+		a, produced := newCapturingAggregator(AggregatorConfig{MaxEvents: 100, MaxBytes: 2*size + 1, Linger: time.Hour})
+		for i := 0; i < 3; i++ {
+			a.add(event, group1)
+		}
+
+		assert.Len(t, unpack(t, <-produced), 2)
+		a.flushAll()
+		assert.Len(t, unpack(t, <-produced), 1)
+	})
+
+	t.Run("Should aggregate per event type and connection group", func(t *testing.T) {
+		a, produced := newCapturingAggregator(AggregatorConfig{MaxEvents: 100, MaxBytes: 1024, Linger: time.Hour})
+		a.add(event, group1)
+		a.add(event, "group-2")
+		a.add(&pb.Event{EventBytes: []byte("debug"), Type: "debug"}, group1)
+		a.flushAll()
+
+		assert.Len(t, produced, 3)
+	})
+
+	t.Run("Should refuse the events added once stopped", func(t *testing.T) {
+		a, produced := newCapturingAggregator(AggregatorConfig{MaxEvents: 100, MaxBytes: 1024, Linger: time.Hour})
+		assert.NoError(t, a.add(event, group1))
+		a.stop()
+		assert.Len(t, unpack(t, <-produced), 1)
+
+		assert.Equal(t, errAggregatorClosed, a.add(event, group1))
+		a.flushAll()
+		assert.Empty(t, produced)
+		a.close()
+	})
+}
+
+func TestKafka_ProduceBulkWithAggregation(t *testing.T) {
+	t.Run("Should aggregate configured event types only", func(t *testing.T) {
+		client := &mockClient{}
+		client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+			return len(m.Headers) == 0
+		}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			go func() {
+				args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+			}()
+		}).Once()
+		client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+			return len(m.Headers) == 1 && string(m.Headers[0].Value) == "2"
+		}), mock.Anything).Return(nil).Once()
+		client.On("Flush", 10).Return(0)
+		client.On("Close").Return()
+		kp := NewKafkaFromClient(client, 10, "%s")
+		kp.EnableAggregation(AggregatorConfig{EventTypes: []string{"scroll"}, MaxEvents: 100, MaxBytes: 1024, Linger: time.Hour})
+
+		err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: "scroll"}, {EventBytes: []byte{}, Type: "click"}, {EventBytes: []byte{}, Type: "scroll"}}), group1, make(chan kafka.Event, 3))
+		assert.NoError(t, err)
+		kp.Close()
+		client.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,64 @@
+package publisher
+
+import (
+	"sync"
+
+	pb "github.com/odpf/raccoon/proto"
+)
+
+// InFlightEvent is an event handed to a producer whose delivery report has not been received.
+type InFlightEvent struct {
+	ConnGroup string
+	Event     *pb.Event
+	// Headers are the headers the record of the event was produced with, besides the headers of the publisher
+	Headers map[string]string
+}
+
+// inFlight keeps the events of every produced message until its delivery report is received,
+// so that the events still in the producers on shutdown can be accounted for and recovered.
+type inFlight struct {
+	m       sync.Mutex
+	nextID  uint64
+	events  map[uint64][]*pb.Event
+	groups  map[uint64]string
+	headers map[uint64]map[string]string
+}
+
+// add registers the events of a message produced with headers and returns the id to pass in its opaque.
+func (f *inFlight) add(connGroup string, headers map[string]string, events ...*pb.Event) uint64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.events == nil {
+		f.events = make(map[uint64][]*pb.Event)
+		f.groups = make(map[uint64]string)
+		f.headers = make(map[uint64]map[string]string)
+	}
+	f.nextID++
+	f.events[f.nextID] = events
+	f.groups[f.nextID] = connGroup
+	if len(headers) > 0 {
+		f.headers[f.nextID] = headers
+	}
+	return f.nextID
+}
+
+// done forgets the events of a message once its delivery is reported or it failed to be produced.
+func (f *inFlight) done(id uint64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.events, id)
+	delete(f.groups, id)
+	delete(f.headers, id)
+}
+
+func (f *inFlight) remaining() []InFlightEvent {
+	f.m.Lock()
+	defer f.m.Unlock()
+	var remaining []InFlightEvent
+	for id, events := range f.events {
+		for _, event := range events {
+			remaining = append(remaining, InFlightEvent{ConnGroup: f.groups[id], Event: event, Headers: f.headers[id]})
+		}
+	}
+	return remaining
+}
@@ -1,9 +1,14 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
 	// Importing librd to make it work on vendor mode
@@ -15,102 +20,353 @@ import (
 	pb "github.com/odpf/raccoon/proto"
 )
 
+// ErrDeliveryTimeout is set for the events whose delivery report was not received in time.
+var ErrDeliveryTimeout = errors.New("delivery report not received")
+
 // KafkaProducer Produce data to kafka synchronously
 type KafkaProducer interface {
-	// ProduceBulk message to kafka. Block until all messages are sent. Return array of error. Order is not guaranteed.
-	ProduceBulk(events []*pb.Event, connGroup string, deliveryChannel chan kafka.Event) error
+	// ProduceBulk message to kafka. Block until all messages are sent or ctx is done. Return array of error. Order is not guaranteed.
+	ProduceBulk(ctx context.Context, records []Record, connGroup string, deliveryChannel chan kafka.Event) error
+}
+
+// Record is an event to publish with the headers of its Kafka record.
+type Record struct {
+	Event *pb.Event
+	// Key is the key of the record, the records of a same key going to the same partition. Nil spreads the records
+	// over the partitions.
+	Key []byte
+	// Headers are set on the record besides the headers of the publisher. Aggregated records do not get them.
+	Headers map[string]string
 }
 
-func NewKafka() (*Kafka, error) {
-	kp, err := newKafkaClient(config.PublisherKafka.ToKafkaConfigMap())
+// SampleRateHeader is set on the records of sampled events, its value is the fraction of the events of their group
+// and type which are kept. Consumers divide by it to estimate the counts before sampling.
+const SampleRateHeader = "raccoon-sample-rate"
+
+// DefaultProfile is the name of the producer used for event types without a producer profile.
+const DefaultProfile = "default"
+
+// newConfiguredMockCluster starts the mock cluster when PUBLISHER_KAFKA_MOCK_BROKERS is set, returns nil otherwise.
+func newConfiguredMockCluster() (*MockCluster, error) {
+	if config.PublisherKafka.MockBrokers <= 0 {
+		return nil, nil
+	}
+	mockCluster, err := NewMockCluster(config.PublisherKafka.MockBrokers)
 	if err != nil {
-		return &Kafka{}, err
+		return nil, err
 	}
-	return &Kafka{
-		kp:            kp,
+	logger.Info(fmt.Sprintf("Publishing to mock cluster at %s", mockCluster.BootstrapServers()))
+	return mockCluster, nil
+}
+
+// newKafka creates the default and profile producers from config. Producers connect to mockCluster instead of the
+// configured brokers when it is not nil, closing the mock cluster is left to the caller.
+func newKafka(name string, mockCluster *MockCluster) (*Kafka, error) {
+	newClient := func(cfg *kafka.ConfigMap) (Client, error) {
+		if mockCluster != nil {
+			cfg.SetKey("bootstrap.servers", mockCluster.BootstrapServers())
+		}
+		return newKafkaClient(cfg)
+	}
+
+	pr := &Kafka{
+		name:          name,
+		profiles:      make(map[string]Client),
+		eventProfiles: make(map[string]string),
 		flushInterval: config.PublisherKafka.FlushInterval,
 		topicFormat:   config.EventDistribution.PublisherPattern,
-	}, nil
+	}
+	kp, err := newClient(config.PublisherKafka.ToKafkaConfigMap())
+	if err != nil {
+		return nil, err
+	}
+	pr.kp = kp
+	for profile, eventTypes := range config.PublisherKafka.Profiles {
+		client, err := newClient(config.PublisherKafka.ToProfileKafkaConfigMap(profile))
+		if err != nil {
+			pr.Close()
+			return nil, fmt.Errorf("producer profile %s: %v", profile, err)
+		}
+		pr.profiles[profile] = client
+		for _, eventType := range eventTypes {
+			pr.eventProfiles[eventType] = profile
+		}
+		logger.Info(fmt.Sprintf("[%s] Producer profile %s publishes event types %v", name, profile, eventTypes))
+	}
+	if len(config.PublisherKafka.AggregationEventTypes) > 0 {
+		pr.EnableAggregation(AggregatorConfig{
+			EventTypes: config.PublisherKafka.AggregationEventTypes,
+			MaxEvents:  config.PublisherKafka.AggregationMaxEvents,
+			MaxBytes:   config.PublisherKafka.AggregationMaxBytes,
+			Linger:     config.PublisherKafka.AggregationLinger,
+		})
+	}
+	return pr, nil
 }
 
 func NewKafkaFromClient(client Client, flushInterval int, topicFormat string) *Kafka {
 	return &Kafka{
+		name:          "producer-0",
 		kp:            client,
+		profiles:      make(map[string]Client),
+		eventProfiles: make(map[string]string),
 		flushInterval: flushInterval,
 		topicFormat:   topicFormat,
 	}
 }
 
 type Kafka struct {
-	kp            Client
+	// bulks counts the ProduceBulk calls, kept first for the 64-bit alignment atomic operations need
+	bulks uint64
+	// name identifies the publisher in the metrics when several of them are pooled
+	name string
+	// kp is the default producer
+	kp Client
+	// profiles holds a producer per configured profile, keyed by profile name
+	profiles map[string]Client
+	// eventProfiles maps event types to the profile they are published with
+	eventProfiles map[string]string
+	// aggregator packs small events into shared records, nil when aggregation is disabled
+	aggregator *aggregator
+	// sampleRate returns the fraction of the events of a group and type kept by sampling, nil when not sampling
+	sampleRate func(connGroup, eventType string) float64
+	// formatter renders the bytes of the events before they are published, nil when they are published as received
+	formatter     Formatter
 	flushInterval int
 	topicFormat   string
+	// inFlight holds the events produced and not yet reported delivered
+	inFlight inFlight
+}
+
+// EnableAggregation publishes the events of the configured types packed into aggregated records.
+// Events of those types no longer wait for their delivery in ProduceBulk.
+func (pr *Kafka) EnableAggregation(cfg AggregatorConfig) {
+	pr.aggregator = newAggregator(cfg, pr.topicFormat, &pr.inFlight, func(eventType string, message *kafka.Message, deliveryChannel chan kafka.Event) error {
+		message.Headers = append(message.Headers, pr.headers(message.Opaque.(aggregatedRecord).connGroup, eventType)...)
+		return pr.client(eventType).Produce(message, deliveryChannel)
+	})
+}
+
+// EnableSampleRateHeader sets the SampleRateHeader on the records of the sampled events, rate returning the fraction
+// of the events of a group and type which are kept. Must be called before publishing.
+func (pr *Kafka) EnableSampleRateHeader(rate func(connGroup, eventType string) float64) {
+	pr.sampleRate = rate
+}
+
+// Formatter renders the bytes of the events of some types before they are published.
+type Formatter interface {
+	// Format returns the bytes to publish for an event of eventType, false when the type is not rendered.
+	Format(eventType string, b []byte) ([]byte, bool, error)
+}
+
+// EnableFormatter publishes the events with the bytes rendered by f. Aggregated events are not rendered, the events
+// kept for recovery are the ones received. Must be called before publishing.
+func (pr *Kafka) EnableFormatter(f Formatter) {
+	pr.formatter = f
+}
+
+// value returns the bytes the event is published with.
+func (pr *Kafka) value(event *pb.Event, connGroup string) ([]byte, error) {
+	if pr.formatter == nil {
+		return event.EventBytes, nil
+	}
+	b, ok, err := pr.formatter.Format(event.Type, event.EventBytes)
+	if err != nil {
+		metrics.Increment("events_conversion_failed_total", "from=proto,conn_group="+connGroup)
+		return nil, fmt.Errorf("failed to format event of type %s: %w", event.Type, err)
+	}
+	if !ok {
+		return event.EventBytes, nil
+	}
+	metrics.Increment("events_converted_total", fmt.Sprintf("from=proto,conn_group=%s,event_type=%s", connGroup, event.Type))
+	return b, nil
+}
+
+// kafkaHeaders returns the headers of a record, sorted by key.
+func kafkaHeaders(headers map[string]string) []kafka.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kHeaders := make([]kafka.Header, len(keys))
+	for i, key := range keys {
+		kHeaders[i] = kafka.Header{Key: key, Value: []byte(headers[key])}
+	}
+	return kHeaders
+}
+
+// headers returns the headers of the records of the events of eventType from connGroup.
+func (pr *Kafka) headers(connGroup, eventType string) []kafka.Header {
+	if pr.sampleRate == nil {
+		return nil
+	}
+	rate := pr.sampleRate(connGroup, eventType)
+	if rate >= 1 {
+		return nil
+	}
+	return []kafka.Header{{Key: SampleRateHeader, Value: []byte(strconv.FormatFloat(rate, 'f', -1, 64))}}
 }
 
-// ProduceBulk messages to kafka. Block until all messages are sent. Return array of error. Order of Errors is guaranteed.
-// DeliveryChannel needs to be exclusive. DeliveryChannel is exposed for recyclability purpose.
-func (pr *Kafka) ProduceBulk(events []*pb.Event, connGroup string, deliveryChannel chan kafka.Event) error {
-	errors := make([]error, len(events))
-	totalProcessed := 0
-	for order, event := range events {
+// client returns the producer of the profile the event type is mapped to, or the default producer.
+func (pr *Kafka) client(eventType string) Client {
+	if profile, ok := pr.eventProfiles[eventType]; ok {
+		return pr.profiles[profile]
+	}
+	return pr.kp
+}
+
+// ProduceBulk messages to kafka. Block until all messages are sent or ctx is done. Return array of error. Order of Errors is guaranteed.
+// Events whose delivery report is not received before ctx is done get an ErrDeliveryTimeout error, they may still be delivered afterwards.
+// DeliveryChannel needs to be exclusive. DeliveryChannel is exposed for recyclability purpose. Once a call returned ErrDeliveryTimeout
+// the outstanding delivery reports are drained in background, the DeliveryChannel must not be reused.
+func (pr *Kafka) ProduceBulk(ctx context.Context, records []Record, connGroup string, deliveryChannel chan kafka.Event) error {
+	errs := make([]error, len(records))
+	bulk := atomic.AddUint64(&pr.bulks, 1)
+	pending := make(map[int]struct{})
+	for order, record := range records {
+		event := record.Event
+		if pr.aggregator != nil && pr.aggregator.accepts(event.Type) {
+			if err := pr.aggregator.add(event, connGroup); err != nil {
+				errs[order] = err
+				metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=false,conn_group=%s,event_type=%s", connGroup, event.Type))
+			}
+			continue
+		}
+		value, err := pr.value(event, connGroup)
+		if err != nil {
+			errs[order] = err
+			metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=false,conn_group=%s,event_type=%s", connGroup, event.Type))
+			continue
+		}
 		topic := fmt.Sprintf(pr.topicFormat, event.Type)
 		message := &kafka.Message{
-			Value:          event.EventBytes,
+			Key:            record.Key,
+			Value:          value,
 			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-			Opaque:         order,
+			Headers:        append(pr.headers(connGroup, event.Type), kafkaHeaders(record.Headers)...),
+			Opaque:         deliveryRef{id: pr.inFlight.add(connGroup, record.Headers, event), bulk: bulk, order: order, eventType: event.Type, connGroup: connGroup},
 		}
 
-		err := pr.kp.Produce(message, deliveryChannel)
+		err = pr.client(event.Type).Produce(message, deliveryChannel)
 		if err != nil {
+			pr.inFlight.done(message.Opaque.(deliveryRef).id)
 			metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=false,conn_group=%s,event_type=%s", connGroup, event.Type))
 			if err.Error() == "Local: Unknown topic" {
-				errors[order] = fmt.Errorf("%v %s", err, topic)
+				errs[order] = fmt.Errorf("%v %s", err, topic)
 				metrics.Increment("kafka_unknown_topic_failure_total", fmt.Sprintf("topic=%s,event_type=%s,conn_group=%s", topic, event.Type, connGroup))
 			} else {
-				errors[order] = err
+				errs[order] = err
 			}
 			continue
 		}
 		metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=true,conn_group=%s,event_type=%s", connGroup, event.Type))
-		totalProcessed++
+		pending[order] = struct{}{}
 	}
 	// Wait for deliveryChannel as many as processed
-	for i := 0; i < totalProcessed; i++ {
-		d := <-deliveryChannel
-		m := d.(*kafka.Message)
-		if m.TopicPartition.Error != nil {
-			eventType := events[i].Type
-			metrics.Decrement("kafka_messages_delivered_total", fmt.Sprintf("success=true,conn_group=%s,event_type=%s", connGroup, eventType))
-			metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=false,conn_group=%s,event_type=%s", connGroup, eventType))
-			order := m.Opaque.(int)
-			errors[order] = m.TopicPartition.Error
+	for len(pending) > 0 {
+		select {
+		case d := <-deliveryChannel:
+			m := d.(*kafka.Message)
+			err := pr.reportDelivery(m)
+			ref, ok := m.Opaque.(deliveryRef)
+			if !ok || ref.bulk != bulk {
+				// late report of a message from an earlier call which timed out
+				continue
+			}
+			delete(pending, ref.order)
+			errs[ref.order] = err
+		case <-ctx.Done():
+			for order := range pending {
+				errs[order] = fmt.Errorf("%w: %v", ErrDeliveryTimeout, ctx.Err())
+				metrics.Increment("kafka_delivery_report_timeout_total", fmt.Sprintf("conn_group=%s,event_type=%s", connGroup, records[order].Event.Type))
+			}
+			logger.Errorf("[%s] gave up waiting for %d delivery reports: %v", pr.name, len(pending), ctx.Err())
+			go pr.drainDeliveries(deliveryChannel, len(pending))
+			pending = nil
 		}
 	}
 
-	if allNil(errors) {
+	if allNil(errs) {
+		return nil
+	}
+	return BulkError{Errors: errs}
+}
+
+// deliveryRef is the opaque of the messages produced by ProduceBulk, it ties delivery reports back to their call.
+type deliveryRef struct {
+	// id identifies the message among the in flight ones
+	id        uint64
+	bulk      uint64
+	order     int
+	eventType string
+	connGroup string
+}
+
+// deliveryError records the metrics of a failed delivery and returns the delivery error.
+func deliveryError(m *kafka.Message) error {
+	if m.TopicPartition.Error == nil {
 		return nil
 	}
-	return BulkError{Errors: errors}
+	var eventType, connGroup string
+	if ref, ok := m.Opaque.(deliveryRef); ok {
+		eventType, connGroup = ref.eventType, ref.connGroup
+	}
+	metrics.Decrement("kafka_messages_delivered_total", fmt.Sprintf("success=true,conn_group=%s,event_type=%s", connGroup, eventType))
+	metrics.Increment("kafka_messages_delivered_total", fmt.Sprintf("success=false,conn_group=%s,event_type=%s", connGroup, eventType))
+	if isUnknownTopic(m.TopicPartition.Error) {
+		metrics.Increment("kafka_unknown_topic_failure_total", fmt.Sprintf("topic=%s,event_type=%s,conn_group=%s", *m.TopicPartition.Topic, eventType, connGroup))
+	}
+	return m.TopicPartition.Error
+}
+
+// reportDelivery accounts for the delivery report of a message produced by ProduceBulk and returns the delivery error.
+func (pr *Kafka) reportDelivery(m *kafka.Message) error {
+	if ref, ok := m.Opaque.(deliveryRef); ok {
+		pr.inFlight.done(ref.id)
+	}
+	return deliveryError(m)
 }
 
+// drainDeliveries consumes the delivery reports left behind by a timed out call so that the producer is never blocked on them.
+func (pr *Kafka) drainDeliveries(deliveryChannel chan kafka.Event, count int) {
+	for i := 0; i < count; i++ {
+		if m, ok := (<-deliveryChannel).(*kafka.Message); ok {
+			pr.reportDelivery(m)
+		}
+	}
+}
+
+// ReportStats reports the librdkafka statistics of every producer, tagged with the producer profile.
 func (pr *Kafka) ReportStats() {
-	for v := range pr.kp.Events() {
+	for profile, client := range pr.profiles {
+		go reportStats(client, fmt.Sprintf("producer=%s,profile=%s", pr.name, profile))
+	}
+	reportStats(pr.kp, fmt.Sprintf("producer=%s,profile=%s", pr.name, DefaultProfile))
+}
+
+func reportStats(client Client, tags string) {
+	for v := range client.Events() {
 		switch e := v.(type) {
 		case *kafka.Stats:
 			var stats map[string]interface{}
 			json.Unmarshal([]byte(e.String()), &stats)
 
 			brokers := stats["brokers"].(map[string]interface{})
-			metrics.Gauge("kafka_tx_messages_total", stats["txmsgs"], "")
-			metrics.Gauge("kafka_tx_messages_bytes_total", stats["txmsg_bytes"], "")
+			metrics.Gauge("kafka_tx_messages_total", stats["txmsgs"], tags)
+			metrics.Gauge("kafka_tx_messages_bytes_total", stats["txmsg_bytes"], tags)
+			metrics.Gauge("kafka_producer_queue_messages_current", stats["msg_cnt"], tags)
 			for _, broker := range brokers {
 				brokerStats := broker.(map[string]interface{})
 				rttValue := brokerStats["rtt"].(map[string]interface{})
 				nodeName := strings.Split(brokerStats["nodename"].(string), ":")[0]
 
-				metrics.Gauge("kafka_brokers_tx_total", brokerStats["tx"], fmt.Sprintf("broker=%s", nodeName))
-				metrics.Gauge("kafka_brokers_tx_bytes_total", brokerStats["txbytes"], fmt.Sprintf("broker=%s", nodeName))
-				metrics.Gauge("kafka_brokers_rtt_average_milliseconds", rttValue["avg"], fmt.Sprintf("broker=%s", nodeName))
+				metrics.Gauge("kafka_brokers_tx_total", brokerStats["tx"], fmt.Sprintf("broker=%s,%s", nodeName, tags))
+				metrics.Gauge("kafka_brokers_tx_bytes_total", brokerStats["txbytes"], fmt.Sprintf("broker=%s,%s", nodeName, tags))
+				metrics.Gauge("kafka_brokers_rtt_average_milliseconds", rttValue["avg"], fmt.Sprintf("broker=%s,%s", nodeName, tags))
 			}
 
 		default:
@@ -120,13 +376,42 @@ func (pr *Kafka) ReportStats() {
 }
 
 // Close wait for outstanding messages to be delivered within given flush interval timeout.
+// Returns the number of messages left undelivered across all producers.
 func (pr *Kafka) Close() int {
-	remaining := pr.kp.Flush(pr.flushInterval)
-	logger.Info(fmt.Sprintf("Outstanding events still un-flushed : %d", remaining))
-	pr.kp.Close()
+	if pr.aggregator != nil {
+		pr.aggregator.stop()
+	}
+	remaining := 0
+	for profile, client := range pr.profiles {
+		r := client.Flush(pr.flushInterval)
+		logger.Info(fmt.Sprintf("[%s] Outstanding events still un-flushed on %s profile : %d", pr.name, profile, r))
+		client.Close()
+		remaining += r
+	}
+	if pr.kp != nil {
+		r := pr.kp.Flush(pr.flushInterval)
+		logger.Info(fmt.Sprintf("[%s] Outstanding events still un-flushed : %d", pr.name, r))
+		pr.kp.Close()
+		remaining += r
+	}
+	if pr.aggregator != nil {
+		pr.aggregator.close()
+	}
 	return remaining
 }
 
+// InFlight returns the events produced whose delivery has not been reported. Once closed, these are the events
+// left undelivered in the producers.
+func (pr *Kafka) InFlight() []InFlightEvent {
+	return pr.inFlight.remaining()
+}
+
+// isUnknownTopic reports whether a delivery failed because the broker does not know the topic.
+func isUnknownTopic(err error) bool {
+	kErr, ok := err.(kafka.Error)
+	return ok && (kErr.Code() == kafka.ErrUnknownTopicOrPart || kErr.Code() == kafka.ErrUnknownTopic)
+}
+
 func allNil(errors []error) bool {
 	for _, err := range errors {
 		if err != nil {
@@ -1,14 +1,22 @@
 package publisher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
 	pb "github.com/odpf/raccoon/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
 )
 
@@ -16,6 +24,12 @@ const (
 	group1 = "group-1"
 )
 
+type formatterFunc func(eventType string, b []byte) ([]byte, bool, error)
+
+func (f formatterFunc) Format(eventType string, b []byte) ([]byte, bool, error) {
+	return f(eventType, b)
+}
+
 type void struct{}
 
 func (v void) Write(_ []byte) (int, error) {
@@ -26,6 +40,38 @@ func TestMain(t *testing.M) {
 	os.Exit(t.Run())
 }
 
+// captureMetrics sends the metrics to a local statsd listener until the test ends, and returns a function giving the
+// metrics received so far.
+func captureMetrics(t *testing.T) func() string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, metrics.SetAddress(conn.LocalAddr().String(), 10*time.Millisecond))
+	var m sync.Mutex
+	var received strings.Builder
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m.Lock()
+			received.Write(buf[:n])
+			received.WriteString("\n")
+			m.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		metrics.SetVoid()
+		conn.Close()
+	})
+	return func() string {
+		m.Lock()
+		defer m.Unlock()
+		return received.String()
+	}
+}
+
 func TestProducer_Close(suite *testing.T) {
 	suite.Run("Should flush before closing the client", func(t *testing.T) {
 		client := &mockClient{}
@@ -37,6 +83,32 @@ func TestProducer_Close(suite *testing.T) {
 	})
 }
 
+func TestProducer_CloseWithProfiles(t *testing.T) {
+	t.Run("Should flush and close every profile producer", func(t *testing.T) {
+		client := &mockClient{}
+		client.On("Flush", 10).Return(1)
+		client.On("Close").Return()
+		critical := &mockClient{}
+		critical.On("Flush", 10).Return(2)
+		critical.On("Close").Return()
+		kp := NewKafkaFromClient(client, 10, "%s")
+		kp.profiles["critical"] = critical
+
+		assert.Equal(t, 3, kp.Close())
+		client.AssertExpectations(t)
+		critical.AssertExpectations(t)
+	})
+}
+
+// recordsOf returns the records of events without headers.
+func recordsOf(events []*pb.Event) []Record {
+	records := make([]Record, len(events))
+	for i, e := range events {
+		records[i] = Record{Event: e}
+	}
+	return records
+}
+
 func TestKafka_ProduceBulk(suite *testing.T) {
 	suite.Parallel()
 	topic := "test_topic"
@@ -52,13 +124,141 @@ func TestKafka_ProduceBulk(suite *testing.T) {
 							Offset:    0,
 							Error:     nil,
 						},
+						Opaque: args.Get(0).(*kafka.Message).Opaque,
 					}
 				}()
 			})
 			kp := NewKafkaFromClient(client, 10, "%s")
 
-			err := kp.ProduceBulk([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}, group1, make(chan kafka.Event, 2))
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}), group1, make(chan kafka.Event, 2))
+			assert.NoError(t, err)
+		})
+	})
+
+	suite.Run("ProducerProfiles", func(t *testing.T) {
+		t.Run("Should produce mapped event types with the profile producer", func(t *testing.T) {
+			deliver := func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(deliver).Once()
+			critical := &mockClient{}
+			critical.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+				return *m.TopicPartition.Topic == "payment"
+			}), mock.Anything).Return(nil).Run(deliver).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+			kp.profiles["critical"] = critical
+			kp.eventProfiles["payment"] = "critical"
+
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: "payment"}, {EventBytes: []byte{}, Type: topic}}), group1, make(chan kafka.Event, 2))
 			assert.NoError(t, err)
+			client.AssertExpectations(t)
+			critical.AssertExpectations(t)
+		})
+	})
+
+	suite.Run("SampleRateHeader", func(t *testing.T) {
+		t.Run("Should set the sample rate on the records of sampled events", func(t *testing.T) {
+			headers := make(map[string][]kafka.Header)
+			var m sync.Mutex
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				message := args.Get(0).(*kafka.Message)
+				m.Lock()
+				headers[*message.TopicPartition.Topic] = message.Headers
+				m.Unlock()
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: message.TopicPartition, Opaque: message.Opaque}
+				}()
+			})
+			kp := NewKafkaFromClient(client, 10, "%s")
+			kp.EnableAggregation(AggregatorConfig{EventTypes: []string{"debug"}, MaxEvents: 1, MaxBytes: 1024, Linger: time.Hour})
+			kp.EnableSampleRateHeader(func(connGroup, eventType string) float64 {
+				if eventType == "click" {
+					return 1
+				}
+				return 0.25
+			})
+
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: "scroll"}, {EventBytes: []byte{}, Type: "click"}, {EventBytes: []byte{}, Type: "debug"}}), group1, make(chan kafka.Event, 2))
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				m.Lock()
+				defer m.Unlock()
+				return len(headers) == 3
+			}, time.Second, time.Millisecond)
+			assert.Equal(t, []kafka.Header{{Key: SampleRateHeader, Value: []byte("0.25")}}, headers["scroll"])
+			assert.Empty(t, headers["click"])
+			assert.Equal(t, []kafka.Header{{Key: AggregatedEventsHeader, Value: []byte("1")}, {Key: SampleRateHeader, Value: []byte("0.25")}}, headers["debug"])
+		})
+	})
+
+	suite.Run("EventHeaders", func(t *testing.T) {
+		t.Run("Should set the key and headers of the record of each event", func(t *testing.T) {
+			client := &mockClient{}
+			client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+				return *m.TopicPartition.Topic == "click" && string(m.Key) == "12345" && assert.ObjectsAreEqual([]kafka.Header{{Key: "raccoon-client-ip", Value: []byte("1.2.3.4")}, {Key: "raccoon-ua-os", Value: []byte("iOS")}}, m.Headers)
+			}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+				return *m.TopicPartition.Topic == "scroll" && m.Key == nil && len(m.Headers) == 0
+			}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+
+			records := []Record{
+				{Event: &pb.Event{EventBytes: []byte{}, Type: "click"}, Key: []byte("12345"), Headers: map[string]string{"raccoon-ua-os": "iOS", "raccoon-client-ip": "1.2.3.4"}},
+				{Event: &pb.Event{EventBytes: []byte{}, Type: "scroll"}},
+			}
+			err := kp.ProduceBulk(context.Background(), records, group1, make(chan kafka.Event, 2))
+			assert.NoError(t, err)
+			client.AssertExpectations(t)
+		})
+	})
+
+	suite.Run("Formatter", func(t *testing.T) {
+		t.Run("Should publish the events with the formatted bytes", func(t *testing.T) {
+			client := &mockClient{}
+			client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+				return *m.TopicPartition.Topic == "click" && string(m.Value) == `{"id":"a"}`
+			}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			client.On("Produce", mock.MatchedBy(func(m *kafka.Message) bool {
+				return *m.TopicPartition.Topic == "scroll" && string(m.Value) == "scrolled"
+			}), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+			kp.EnableFormatter(formatterFunc(func(eventType string, b []byte) ([]byte, bool, error) {
+				switch eventType {
+				case "click":
+					return []byte(`{"id":"` + string(b) + `"}`), true, nil
+				case "bad":
+					return nil, true, errors.New("malformed")
+				}
+				return nil, false, nil
+			}))
+
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte("a"), Type: "click"}, {EventBytes: []byte("b"), Type: "bad"}, {EventBytes: []byte("scrolled"), Type: "scroll"}}), group1, make(chan kafka.Event, 2))
+			var bulkErr BulkError
+			assert.True(t, errors.As(err, &bulkErr))
+			assert.NoError(t, bulkErr.Errors[0])
+			assert.Error(t, bulkErr.Errors[1])
+			assert.NoError(t, bulkErr.Errors[2])
+			client.AssertExpectations(t)
 		})
 	})
 
@@ -75,13 +275,14 @@ func TestKafka_ProduceBulk(suite *testing.T) {
 							Offset:    0,
 							Error:     nil,
 						},
+						Opaque: args.Get(0).(*kafka.Message).Opaque,
 					}
 				}()
 			}).Once()
 			client.On("Produce", mock.Anything, mock.Anything).Return(fmt.Errorf("buffer full")).Once()
 			kp := NewKafkaFromClient(client, 10, "%s")
 
-			err := kp.ProduceBulk([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}, group1, make(chan kafka.Event, 2))
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}), group1, make(chan kafka.Event, 2))
 			assert.Len(t, err.(BulkError).Errors, 3)
 			assert.Error(t, err.(BulkError).Errors[0])
 			assert.Empty(t, err.(BulkError).Errors[1])
@@ -89,12 +290,40 @@ func TestKafka_ProduceBulk(suite *testing.T) {
 		})
 
 		t.Run("Should return topic name when unknown topic is returned", func(t *testing.T) {
+			received := captureMetrics(t)
 			client := &mockClient{}
 			client.On("Produce", mock.Anything, mock.Anything).Return(fmt.Errorf("Local: Unknown topic")).Once()
 			kp := NewKafkaFromClient(client, 10, "%s")
 
-			err := kp.ProduceBulk([]*pb.Event{{EventBytes: []byte{}, Type: topic}}, "group1", make(chan kafka.Event, 2))
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}}), "group1", make(chan kafka.Event, 2))
 			assert.EqualError(t, err.(BulkError).Errors[0], "Local: Unknown topic "+topic)
+			assert.Eventually(t, func() bool {
+				return strings.Contains(received(), fmt.Sprintf("kafka_unknown_topic_failure_total,topic=%s,event_type=%s,conn_group=group1:1|c", topic, topic))
+			}, time.Second, 10*time.Millisecond)
+		})
+
+		t.Run("Should count the unknown topic errors of the delivery reports", func(t *testing.T) {
+			received := captureMetrics(t)
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{
+						TopicPartition: kafka.TopicPartition{
+							Topic: args.Get(0).(*kafka.Message).TopicPartition.Topic,
+							Error: kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false),
+						},
+						Opaque: args.Get(0).(*kafka.Message).Opaque,
+					}
+				}()
+			}).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}}), "group1", make(chan kafka.Event, 2))
+			require.IsType(t, BulkError{}, err)
+			assert.Equal(t, kafka.ErrUnknownTopicOrPart, err.(BulkError).Errors[0].(kafka.Error).Code())
+			assert.Eventually(t, func() bool {
+				return strings.Contains(received(), fmt.Sprintf("kafka_unknown_topic_failure_total,topic=%s,event_type=%s,conn_group=group1:1|c", topic, topic))
+			}, time.Second, 10*time.Millisecond)
 		})
 	})
 
@@ -111,17 +340,85 @@ func TestKafka_ProduceBulk(suite *testing.T) {
 							Offset:    0,
 							Error:     fmt.Errorf("timeout"),
 						},
-						Opaque: 1,
+						Opaque: args.Get(0).(*kafka.Message).Opaque,
 					}
 				}()
 			}).Once()
 			kp := NewKafkaFromClient(client, 10, "%s")
 
-			err := kp.ProduceBulk([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}, "group1", make(chan kafka.Event, 2))
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}), "group1", make(chan kafka.Event, 2))
 			assert.NotEmpty(t, err)
 			assert.Len(t, err.(BulkError).Errors, 2)
 			assert.Equal(t, "buffer full", err.(BulkError).Errors[0].Error())
 			assert.Equal(t, "timeout", err.(BulkError).Errors[1].Error())
 		})
 	})
+
+	suite.Run("DeliveryDeadline", func(t *testing.T) {
+		t.Run("Should fail undelivered events when the context is done", func(t *testing.T) {
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			err := kp.ProduceBulk(ctx, recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}, {EventBytes: []byte{}, Type: topic}}), group1, make(chan kafka.Event, 2))
+			assert.Len(t, err.(BulkError).Errors, 2)
+			assert.NoError(t, err.(BulkError).Errors[0])
+			assert.True(t, errors.Is(err.(BulkError).Errors[1], ErrDeliveryTimeout))
+		})
+
+		t.Run("Should keep the events whose delivery is not reported in flight", func(t *testing.T) {
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Once()
+			client.On("Produce", mock.Anything, mock.Anything).Return(fmt.Errorf("buffer full")).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			undelivered := &pb.Event{EventBytes: []byte("undelivered"), Type: topic}
+
+			headers := map[string]string{"raccoon-client-ip": "1.2.3.4"}
+			records := recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}, undelivered, {EventBytes: []byte{}, Type: topic}})
+			records[1].Headers = headers
+
+			kp.ProduceBulk(ctx, records, group1, make(chan kafka.Event, 3))
+			assert.Equal(t, []InFlightEvent{{ConnGroup: group1, Event: undelivered, Headers: headers}}, kp.InFlight())
+		})
+
+		t.Run("Should ignore delivery reports of earlier calls", func(t *testing.T) {
+			client := &mockClient{}
+			client.On("Produce", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				go func() {
+					args.Get(1).(chan kafka.Event) <- &kafka.Message{TopicPartition: args.Get(0).(*kafka.Message).TopicPartition, Opaque: args.Get(0).(*kafka.Message).Opaque}
+				}()
+			}).Once()
+			kp := NewKafkaFromClient(client, 10, "%s")
+			deliveryChannel := make(chan kafka.Event, 2)
+			stale := topic
+			deliveryChannel <- &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: &stale, Error: fmt.Errorf("timeout")},
+				Opaque:         deliveryRef{bulk: 42, order: 0, eventType: topic, connGroup: group1},
+			}
+
+			err := kp.ProduceBulk(context.Background(), recordsOf([]*pb.Event{{EventBytes: []byte{}, Type: topic}}), group1, deliveryChannel)
+			assert.NoError(t, err)
+		})
+	})
+}
+
+func TestIsUnknownTopic(t *testing.T) {
+	assert.True(t, isUnknownTopic(kafka.NewError(kafka.ErrUnknownTopicOrPart, "", false)))
+	assert.True(t, isUnknownTopic(kafka.NewError(kafka.ErrUnknownTopic, "", false)))
+	assert.False(t, isUnknownTopic(kafka.NewError(kafka.ErrMsgTimedOut, "", false)))
+	assert.False(t, isUnknownTopic(fmt.Errorf("timeout")))
 }
@@ -0,0 +1,107 @@
+package publisher
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+
+// The mock cluster API is part of the bundled librdkafka but its header is not shipped with the Go client,
+// so the handful of functions used here are declared by hand.
+typedef struct rd_kafka_s rd_kafka_t;
+typedef struct rd_kafka_conf_s rd_kafka_conf_t;
+typedef struct rd_kafka_mock_cluster_s rd_kafka_mock_cluster_t;
+
+rd_kafka_conf_t *rd_kafka_conf_new(void);
+rd_kafka_t *rd_kafka_new(int type, rd_kafka_conf_t *conf, char *errstr, size_t errstr_size);
+void rd_kafka_destroy(rd_kafka_t *rk);
+
+rd_kafka_mock_cluster_t *rd_kafka_mock_cluster_new(rd_kafka_t *rk, int broker_cnt);
+void rd_kafka_mock_cluster_destroy(rd_kafka_mock_cluster_t *mcluster);
+const char *rd_kafka_mock_cluster_bootstraps(const rd_kafka_mock_cluster_t *mcluster);
+void rd_kafka_mock_push_request_errors(rd_kafka_mock_cluster_t *mcluster, int16_t ApiKey, size_t cnt, ...);
+int rd_kafka_mock_broker_set_down(rd_kafka_mock_cluster_t *mcluster, int32_t broker_id);
+int rd_kafka_mock_broker_set_up(rd_kafka_mock_cluster_t *mcluster, int32_t broker_id);
+
+// cgo cannot call variadic functions, push the errors one at a time instead.
+static void push_request_error(rd_kafka_mock_cluster_t *mcluster, int16_t ApiKey, int err) {
+	rd_kafka_mock_push_request_errors(mcluster, ApiKey, 1, err);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
+)
+
+// ProduceRequestKey is the kafka protocol ApiKey of Produce requests.
+const ProduceRequestKey int16 = 0
+
+// MockCluster is an in-process kafka cluster backed by librdkafka mock brokers. It is meant for tests and local runs,
+// the data is kept in memory and lost once the cluster is closed.
+type MockCluster struct {
+	rk         *C.rd_kafka_t
+	mc         *C.rd_kafka_mock_cluster_t
+	bootstraps string
+}
+
+// NewMockCluster starts a mock cluster with the given number of brokers.
+func NewMockCluster(brokers int) (*MockCluster, error) {
+	if brokers < 1 {
+		return nil, fmt.Errorf("mock cluster needs at least 1 broker, got %d", brokers)
+	}
+	errstr := (*C.char)(C.malloc(512))
+	defer C.free(unsafe.Pointer(errstr))
+
+	// The mock cluster runs on the thread of a librdkafka handle, a plain producer handle is created to host it.
+	rk := C.rd_kafka_new(C.int(0), C.rd_kafka_conf_new(), errstr, 512)
+	if rk == nil {
+		return nil, fmt.Errorf("failed to create mock cluster handle: %s", C.GoString(errstr))
+	}
+	mc := C.rd_kafka_mock_cluster_new(rk, C.int(brokers))
+	if mc == nil {
+		C.rd_kafka_destroy(rk)
+		return nil, errors.New("failed to create mock cluster")
+	}
+	return &MockCluster{
+		rk:         rk,
+		mc:         mc,
+		bootstraps: C.GoString(C.rd_kafka_mock_cluster_bootstraps(mc)),
+	}, nil
+}
+
+// BootstrapServers returns the comma separated addresses of the mock brokers.
+func (m *MockCluster) BootstrapServers() string {
+	return m.bootstraps
+}
+
+// PushRequestErrors makes the brokers answer the next len(errs) requests of the given ApiKey with errs, in order.
+func (m *MockCluster) PushRequestErrors(apiKey int16, errs ...kafka.ErrorCode) {
+	for _, err := range errs {
+		C.push_request_error(m.mc, C.int16_t(apiKey), C.int(err))
+	}
+}
+
+// SetBrokerDown disconnects the broker and stops accepting connections on it until SetBrokerUp is called.
+func (m *MockCluster) SetBrokerDown(brokerID int32) error {
+	if code := C.rd_kafka_mock_broker_set_down(m.mc, C.int32_t(brokerID)); code != 0 {
+		return kafka.NewError(kafka.ErrorCode(code), "failed to set broker down", false)
+	}
+	return nil
+}
+
+// SetBrokerUp makes a broker previously set down accept connections again.
+func (m *MockCluster) SetBrokerUp(brokerID int32) error {
+	if code := C.rd_kafka_mock_broker_set_up(m.mc, C.int32_t(brokerID)); code != 0 {
+		return kafka.NewError(kafka.ErrorCode(code), "failed to set broker up", false)
+	}
+	return nil
+}
+
+// Close stops the mock brokers. Producers connected to the cluster should be closed beforehand.
+func (m *MockCluster) Close() {
+	C.rd_kafka_mock_cluster_destroy(m.mc)
+	C.rd_kafka_destroy(m.rk)
+}
@@ -0,0 +1,104 @@
+package publisher
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
+)
+
+func newMockClusterKafka(t *testing.T, brokers int, extra kafka.ConfigMap) (*Kafka, *MockCluster) {
+	mc, err := NewMockCluster(brokers)
+	require.NoError(t, err)
+	// runs after the publisher is closed by the test
+	t.Cleanup(mc.Close)
+	cfg := kafka.ConfigMap{
+		"bootstrap.servers":        mc.BootstrapServers(),
+		"message.send.max.retries": 0,
+	}
+	for k, v := range extra {
+		cfg[k] = v
+	}
+	client, err := newKafkaClient(&cfg)
+	require.NoError(t, err)
+	return NewKafkaFromClient(client, 1000, "clickstream-%s-log"), mc
+}
+
+func TestKafka_ProduceBulkOnMockCluster(suite *testing.T) {
+	events := []*pb.Event{{EventBytes: []byte("1"), Type: "click"}, {EventBytes: []byte("2"), Type: "buy"}}
+
+	suite.Run("Should return nil when all messages are delivered", func(t *testing.T) {
+		kp, _ := newMockClusterKafka(t, 3, nil)
+		defer kp.Close()
+
+		err := kp.ProduceBulk(context.Background(), recordsOf(events), group1, make(chan kafka.Event, 2))
+		assert.NoError(t, err)
+	})
+
+	suite.Run("Should return broker error from the delivery report", func(t *testing.T) {
+		kp, mc := newMockClusterKafka(t, 1, nil)
+		defer kp.Close()
+		mc.PushRequestErrors(ProduceRequestKey, kafka.ErrNotEnoughReplicas)
+
+		err := kp.ProduceBulk(context.Background(), recordsOf(events[:1]), group1, make(chan kafka.Event, 1))
+		require.IsType(t, BulkError{}, err)
+		assert.Len(t, err.(BulkError).Errors, 1)
+		assert.Equal(t, kafka.ErrNotEnoughReplicas, err.(BulkError).Errors[0].(kafka.Error).Code())
+	})
+
+	suite.Run("Should deliver after transient unknown topic errors", func(t *testing.T) {
+		kp, mc := newMockClusterKafka(t, 1, nil)
+		defer kp.Close()
+		// librdkafka refreshes the metadata and retries unknown topic errors regardless of the retry config.
+		mc.PushRequestErrors(ProduceRequestKey, kafka.ErrUnknownTopicOrPart, kafka.ErrUnknownTopicOrPart)
+
+		err := kp.ProduceBulk(context.Background(), recordsOf(events[:1]), group1, make(chan kafka.Event, 1))
+		assert.NoError(t, err)
+	})
+
+	suite.Run("Should fail the events of a topic which stays unknown once they time out", func(t *testing.T) {
+		received := captureMetrics(t)
+		kp, mc := newMockClusterKafka(t, 1, kafka.ConfigMap{"message.timeout.ms": 500})
+		defer kp.Close()
+		// librdkafka keeps retrying until the messages time out, they are not reported as unknown topic errors.
+		for i := 0; i < 1000; i++ {
+			mc.PushRequestErrors(ProduceRequestKey, kafka.ErrUnknownTopicOrPart)
+		}
+
+		err := kp.ProduceBulk(context.Background(), recordsOf(events), group1, make(chan kafka.Event, 2))
+		require.IsType(t, BulkError{}, err)
+		require.Len(t, err.(BulkError).Errors, 2)
+		for _, e := range err.(BulkError).Errors {
+			assert.Equal(t, kafka.ErrMsgTimedOut, e.(kafka.Error).Code())
+		}
+		assert.Eventually(t, func() bool {
+			return strings.Contains(received(), "kafka_messages_delivered_total,success=false,conn_group=group-1,event_type=click:1|c") &&
+				strings.Contains(received(), "kafka_messages_delivered_total,success=false,conn_group=group-1,event_type=buy:1|c")
+		}, time.Second, 10*time.Millisecond)
+		assert.NotContains(t, received(), "kafka_unknown_topic_failure_total")
+	})
+
+	suite.Run("Should time out messages when the broker is down", func(t *testing.T) {
+		kp, mc := newMockClusterKafka(t, 1, kafka.ConfigMap{"message.timeout.ms": 500})
+		defer kp.Close()
+		require.NoError(t, mc.SetBrokerDown(1))
+
+		err := kp.ProduceBulk(context.Background(), recordsOf(events), group1, make(chan kafka.Event, 2))
+		require.IsType(t, BulkError{}, err)
+		for _, e := range err.(BulkError).Errors {
+			assert.Equal(t, kafka.ErrMsgTimedOut, e.(kafka.Error).Code())
+		}
+	})
+}
+
+func TestNewMockCluster(t *testing.T) {
+	t.Run("Should reject cluster without brokers", func(t *testing.T) {
+		_, err := NewMockCluster(0)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,102 @@
+package publisher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/odpf/raccoon/config"
+)
+
+// Pool holds several Kafka publishers, each with its own librdkafka producers and queues,
+// so that compression and network I/O can be spread over more cores.
+type Pool struct {
+	publishers  []*Kafka
+	mockCluster *MockCluster
+}
+
+// NewKafkaPool creates PUBLISHER_KAFKA_PRODUCER_POOL_SIZE publishers from config.
+func NewKafkaPool() (*Pool, error) {
+	mockCluster, err := newConfiguredMockCluster()
+	if err != nil {
+		return nil, err
+	}
+	p := &Pool{mockCluster: mockCluster}
+	for i := 0; i < config.PublisherKafka.ProducerPoolSize; i++ {
+		pr, err := newKafka(fmt.Sprintf("producer-%d", i), mockCluster)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.publishers = append(p.publishers, pr)
+	}
+	return p, nil
+}
+
+// NewPoolFromPublishers creates a pool out of existing publishers.
+func NewPoolFromPublishers(publishers ...*Kafka) *Pool {
+	return &Pool{publishers: publishers}
+}
+
+// Get returns the publisher assigned to the key. The same key is always assigned to the same publisher. Keys ending with
+// an index, as the names of the workers, are assigned round-robin by index so that the workers are spread evenly, the
+// other keys by hash.
+func (p *Pool) Get(key string) KafkaProducer {
+	if i := strings.LastIndexByte(key, '-'); i >= 0 {
+		if index, err := strconv.Atoi(key[i+1:]); err == nil && index >= 0 {
+			return p.publishers[index%len(p.publishers)]
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return p.publishers[h.Sum32()%uint32(len(p.publishers))]
+}
+
+// EnableSampleRateHeader sets the SampleRateHeader on the records of every publisher, see Kafka.EnableSampleRateHeader.
+func (p *Pool) EnableSampleRateHeader(rate func(connGroup, eventType string) float64) {
+	for _, pr := range p.publishers {
+		pr.EnableSampleRateHeader(rate)
+	}
+}
+
+// EnableFormatter publishes the events of every publisher with the bytes rendered by f, see Kafka.EnableFormatter.
+func (p *Pool) EnableFormatter(f Formatter) {
+	for _, pr := range p.publishers {
+		pr.EnableFormatter(f)
+	}
+}
+
+// Size returns the number of publishers in the pool.
+func (p *Pool) Size() int {
+	return len(p.publishers)
+}
+
+// ReportStats reports the statistics of every publisher. Blocks until the publishers are closed.
+func (p *Pool) ReportStats() {
+	for _, pr := range p.publishers[1:] {
+		go pr.ReportStats()
+	}
+	p.publishers[0].ReportStats()
+}
+
+// InFlight returns the events produced whose delivery has not been reported, across every publisher.
+func (p *Pool) InFlight() []InFlightEvent {
+	var events []InFlightEvent
+	for _, pr := range p.publishers {
+		events = append(events, pr.InFlight()...)
+	}
+	return events
+}
+
+// Close closes every publisher and returns the total number of messages left undelivered.
+func (p *Pool) Close() int {
+	remaining := 0
+	for _, pr := range p.publishers {
+		remaining += pr.Close()
+	}
+	if p.mockCluster != nil {
+		p.mockCluster.Close()
+	}
+	return remaining
+}
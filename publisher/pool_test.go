@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	newPool := func(size int) (*Pool, []*mockClient) {
+		var publishers []*Kafka
+		var clients []*mockClient
+		for i := 0; i < size; i++ {
+			client := &mockClient{}
+			clients = append(clients, client)
+			publishers = append(publishers, NewKafkaFromClient(client, 10, "%s"))
+		}
+		return NewPoolFromPublishers(publishers...), clients
+	}
+
+	t.Run("Should assign the same publisher to the same key", func(t *testing.T) {
+		p, _ := newPool(4)
+		assert.Same(t, p.Get("worker-1"), p.Get("worker-1"))
+	})
+
+	t.Run("Should spread keys over the publishers", func(t *testing.T) {
+		p, _ := newPool(3)
+		used := make(map[KafkaProducer]bool)
+		for i := 0; i < 30; i++ {
+			used[p.Get(fmt.Sprintf("key%d", i))] = true
+		}
+		assert.Len(t, used, 3)
+	})
+
+	t.Run("Should assign the workers round-robin by index", func(t *testing.T) {
+		p, _ := newPool(3)
+		for _, prefix := range []string{"worker", "priority-worker"} {
+			assigned := make(map[KafkaProducer]int)
+			for i := 0; i < 7; i++ {
+				assigned[p.Get(fmt.Sprintf("%s-%d", prefix, i))]++
+			}
+			assert.Equal(t, map[KafkaProducer]int{p.publishers[0]: 3, p.publishers[1]: 2, p.publishers[2]: 2}, assigned)
+		}
+	})
+
+	t.Run("Should close every publisher and sum the undelivered messages", func(t *testing.T) {
+		p, clients := newPool(2)
+		for i, client := range clients {
+			client.On("Flush", 10).Return(i + 1)
+			client.On("Close").Return()
+		}
+		assert.Equal(t, 3, p.Close())
+		for _, client := range clients {
+			client.AssertExpectations(t)
+		}
+	})
+}
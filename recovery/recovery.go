@@ -0,0 +1,160 @@
+package recovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// ConnID is the connection id of the replayed requests.
+const ConnID = "recovery"
+
+// record is a line of the recovery file.
+type record struct {
+	ConnGroup string `json:"conn_group"`
+	// Request is the serialized SendEventRequest
+	Request []byte `json:"request"`
+	// Enrichment is the enrichment the collector stages added to the request
+	Enrichment map[string]string `json:"enrichment,omitempty"`
+	// EventHeaders is the headers the collector stages added to single events, by index of the event
+	EventHeaders map[int]map[string]string `json:"event_headers,omitempty"`
+}
+
+// Persist writes the requests to the recovery file at path, replacing it. Returns the number of events written.
+func Persist(path string, requests []collection.CollectRequest) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	events, err := write(f, requests)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return events, os.Rename(tmp, path)
+}
+
+// Append adds the requests at the end of the file at path, which is created if missing. The file has the format of
+// the recovery file. Returns the number of events written.
+func Append(path string, requests []collection.CollectRequest) (int, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	return write(f, requests)
+}
+
+// write encodes the requests to f, then closes it.
+func write(f *os.File, requests []collection.CollectRequest) (int, error) {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	events := 0
+	for _, req := range requests {
+		b, err := proto.Marshal(req.SendEventRequest)
+		if err == nil {
+			err = enc.Encode(record{
+				ConnGroup:    req.ConnectionIdentifier.Group,
+				Request:      b,
+				Enrichment:   req.Enrichment,
+				EventHeaders: eventHeaders(req),
+			})
+		}
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		events += len(req.GetEvents())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return events, f.Close()
+}
+
+// Load reads the requests of the recovery file at path. A missing file holds no request.
+func Load(path string) ([]collection.CollectRequest, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var requests []collection.CollectRequest
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("corrupted recovery file %s: %v", path, err)
+		}
+		req := &pb.SendEventRequest{}
+		if err := proto.Unmarshal(r.Request, req); err != nil {
+			return nil, fmt.Errorf("corrupted recovery file %s: %v", path, err)
+		}
+		loaded := collection.CollectRequest{
+			ConnectionIdentifier: identification.Identifier{ID: ConnID, Group: r.ConnGroup},
+			Enrichment:           r.Enrichment,
+			SendEventRequest:     req,
+		}
+		for i, headers := range r.EventHeaders {
+			if i < 0 || i >= len(req.GetEvents()) {
+				return nil, fmt.Errorf("corrupted recovery file %s: headers of event %d out of %d", path, i, len(req.GetEvents()))
+			}
+			for key, value := range headers {
+				loaded.SetEventHeader(req.Events[i], key, value)
+			}
+		}
+		requests = append(requests, loaded)
+	}
+	return requests, nil
+}
+
+// eventHeaders returns the headers of the events of the request by index of the event, nil when there are none.
+func eventHeaders(req collection.CollectRequest) map[int]map[string]string {
+	var headers map[int]map[string]string
+	for i, e := range req.GetEvents() {
+		if h, ok := req.EventHeaders[e]; ok {
+			if headers == nil {
+				headers = make(map[int]map[string]string)
+			}
+			headers[i] = h
+		}
+	}
+	return headers
+}
+
+// Replay collects the requests of the recovery file at path, then removes the file. The requests which could not be
+// collected are persisted back. Returns the number of events replayed.
+func Replay(ctx context.Context, path string, c collection.Collector) (int, error) {
+	requests, err := Load(path)
+	if err != nil || len(requests) == 0 {
+		return 0, err
+	}
+	events := 0
+	for i, req := range requests {
+		req.TimeConsumed = time.Now()
+		if err := c.Collect(ctx, &req); err != nil {
+			if _, perr := Persist(path, requests[i:]); perr != nil {
+				return events, fmt.Errorf("%v, and persisting back failed: %v", err, perr)
+			}
+			return events, err
+		}
+		for _, e := range req.GetEvents() {
+			metrics.Increment("events_replayed_total", fmt.Sprintf("conn_group=%s,event_type=%s", req.ConnectionIdentifier.Group, e.Type))
+		}
+		events += len(req.GetEvents())
+	}
+	return events, os.Remove(path)
+}
@@ -0,0 +1,118 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func requests() []collection.CollectRequest {
+	requests := []collection.CollectRequest{
+		{
+			ConnectionIdentifier: identification.Identifier{ID: "1", Group: "viewer"},
+			Enrichment:           map[string]string{"raccoon-client-ip": "1.2.3.4"},
+			SendEventRequest:     &pb.SendEventRequest{ReqGuid: "a", Events: []*pb.Event{{EventBytes: []byte("x"), Type: "click"}, {EventBytes: []byte("y"), Type: "scroll"}}},
+		},
+		{
+			ConnectionIdentifier: identification.Identifier{ID: "2", Group: "driver"},
+			SendEventRequest:     &pb.SendEventRequest{ReqGuid: "b", Events: []*pb.Event{{EventBytes: []byte("z"), Type: "click"}}},
+		},
+	}
+	requests[0].SetEventHeader(requests[0].Events[1], "raccoon-validation-error", "invalid")
+	return requests
+}
+
+func tempPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "recovery")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "recovery.jsonl")
+}
+
+func TestPersistAndLoad(t *testing.T) {
+	path := tempPath(t)
+
+	events, err := Persist(path, requests())
+	require.NoError(t, err)
+	assert.Equal(t, 3, events)
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	assert.Equal(t, identification.Identifier{ID: ConnID, Group: "viewer"}, loaded[0].ConnectionIdentifier)
+	assert.Equal(t, "a", loaded[0].ReqGuid)
+	assert.Equal(t, []byte("y"), loaded[0].Events[1].EventBytes)
+	assert.Equal(t, "driver", loaded[1].ConnectionIdentifier.Group)
+	assert.Equal(t, map[string]string{"raccoon-client-ip": "1.2.3.4"}, loaded[0].Enrichment)
+	assert.Nil(t, loaded[1].Enrichment)
+	assert.Equal(t, map[string]string{"raccoon-client-ip": "1.2.3.4"}, loaded[0].Headers(loaded[0].Events[0]))
+	assert.Equal(t, map[string]string{"raccoon-client-ip": "1.2.3.4", "raccoon-validation-error": "invalid"}, loaded[0].Headers(loaded[0].Events[1]))
+	assert.Nil(t, loaded[1].EventHeaders)
+
+	missing, err := Load(path + ".missing")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestAppend(t *testing.T) {
+	path := tempPath(t)
+
+	for i := 0; i < 2; i++ {
+		events, err := Append(path, requests()[1:])
+		require.NoError(t, err)
+		assert.Equal(t, 1, events)
+	}
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	assert.Equal(t, "b", loaded[0].ReqGuid)
+	assert.Equal(t, "b", loaded[1].ReqGuid)
+}
+
+func TestReplay(t *testing.T) {
+	metrics.SetVoid()
+
+	t.Run("Should collect every request and remove the file", func(t *testing.T) {
+		path := tempPath(t)
+		_, err := Persist(path, requests())
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		c.On("Collect", mock.Anything, mock.Anything).Return(nil).Twice()
+
+		events, err := Replay(context.Background(), path, c)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, events)
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+		c.AssertExpectations(t)
+	})
+
+	t.Run("Should persist back the requests not collected", func(t *testing.T) {
+		path := tempPath(t)
+		_, err := Persist(path, requests())
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		c.On("Collect", mock.Anything, mock.Anything).Return(nil).Once()
+		c.On("Collect", mock.Anything, mock.Anything).Return(collection.ErrBufferFull).Once()
+
+		events, err := Replay(context.Background(), path, c)
+		assert.True(t, errors.Is(err, collection.ErrBufferFull))
+		assert.Equal(t, 2, events)
+		left, err := Load(path)
+		require.NoError(t, err)
+		require.Len(t, left, 1)
+		assert.Equal(t, "b", left[0].ReqGuid)
+	})
+}
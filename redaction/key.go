@@ -0,0 +1,56 @@
+package redaction
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/odpf/raccoon/logger"
+)
+
+// ReloadKey reads the key file again, the surrounding whitespace being trimmed. Returns true when the key changed.
+// The previous key is kept when the file cannot be read or is empty.
+func (r *Redactor) ReloadKey() (bool, error) {
+	if r.keyFile == "" {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) == 0 {
+		return false, fmt.Errorf("key file %s is empty", r.keyFile)
+	}
+	if current, ok := r.key.Load().([]byte); ok && bytes.Equal(current, key) {
+		return false, nil
+	}
+	r.key.Store(key)
+	return true, nil
+}
+
+// WatchKey reloads the key every interval until ctx is done, so that it is rotated by replacing the key file.
+func (r *Redactor) WatchKey(ctx context.Context, interval time.Duration) {
+	if r.keyFile == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.ReloadKey()
+			if err != nil {
+				logger.Errorf("[redaction] failed to reload key, keeping the previous one: %v", err)
+				continue
+			}
+			if changed {
+				logger.Info(fmt.Sprintf("[redaction] key reloaded from %s", r.keyFile))
+			}
+		}
+	}
+}
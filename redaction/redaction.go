@@ -0,0 +1,215 @@
+package redaction
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Action tells how a field is redacted.
+type Action string
+
+const (
+	// ActionDrop clears the field.
+	ActionDrop Action = "drop"
+	// ActionMask replaces every character of a string field, or every byte of a bytes field, with *.
+	ActionMask Action = "mask"
+	// ActionHash replaces a string field with the hex encoded HMAC-SHA256 of its value, a bytes field with the HMAC.
+	ActionHash Action = "hash"
+)
+
+// field is a field to redact, path leading from the message of the event to the field.
+type field struct {
+	path   []protoreflect.FieldDescriptor
+	action Action
+}
+
+// Redactor is a collector stage redacting fields of the events before they are buffered, so that they are never
+// published. Events of types without fields to redact are left as is.
+type Redactor struct {
+	registry *schema.Registry
+	fields   map[string][]field
+	// key is the []byte the fields are hashed with
+	key atomic.Value
+	// keyFile is where the key is read from, empty when no field is hashed
+	keyFile string
+}
+
+// New creates a redactor of the fields of the messages of registry. fields maps the event types to the dot separated
+// path of their fields, in the protobuf names, to the action applied to them. Messages in a path may be repeated, the
+// field is redacted in each of them. Hashed fields are hashed with the key in keyFile, which is required when a field
+// is hashed.
+func New(registry *schema.Registry, fields map[string]map[string]string, keyFile string) (*Redactor, error) {
+	r := &Redactor{registry: registry, fields: make(map[string][]field, len(fields))}
+	hashed := false
+	for eventType, paths := range fields {
+		md, ok := registry.Message(eventType)
+		if !ok {
+			return nil, fmt.Errorf("event type %s has no message", eventType)
+		}
+		// paths are applied in order so that the events are redacted the same way every time
+		names := make([]string, 0, len(paths))
+		for name := range paths {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f, err := resolve(md, name, Action(paths[name]))
+			if err != nil {
+				return nil, fmt.Errorf("field %s of event type %s: %w", name, eventType, err)
+			}
+			hashed = hashed || f.action == ActionHash
+			r.fields[eventType] = append(r.fields[eventType], f)
+		}
+	}
+	if hashed {
+		if keyFile == "" {
+			return nil, fmt.Errorf("a key file is required to hash fields")
+		}
+		r.keyFile = keyFile
+		if _, err := r.ReloadKey(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// resolve returns the field at path in the message md.
+func resolve(md protoreflect.MessageDescriptor, path string, action Action) (field, error) {
+	f := field{action: action}
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return f, fmt.Errorf("%s has no field %s", md.FullName(), name)
+		}
+		f.path = append(f.path, fd)
+		if i == len(names)-1 {
+			break
+		}
+		if fd.Message() == nil || fd.IsMap() {
+			return f, fmt.Errorf("%s is not a message", fd.FullName())
+		}
+		md = fd.Message()
+	}
+	leaf := f.path[len(f.path)-1]
+	switch action {
+	case ActionDrop:
+	case ActionMask, ActionHash:
+		if leaf.IsMap() || (leaf.Kind() != protoreflect.StringKind && leaf.Kind() != protoreflect.BytesKind) {
+			return f, fmt.Errorf("only string and bytes fields can be %sed", action)
+		}
+	default:
+		return f, fmt.Errorf("unknown action %s", action)
+	}
+	return f, nil
+}
+
+// redact applies the action of f to the field at path in m.
+func (r *Redactor) redact(m protoreflect.Message, path []protoreflect.FieldDescriptor, action Action) {
+	fd := path[0]
+	if !m.Has(fd) {
+		return
+	}
+	if len(path) > 1 {
+		if fd.IsList() {
+			l := m.Mutable(fd).List()
+			for i := 0; i < l.Len(); i++ {
+				r.redact(l.Get(i).Message(), path[1:], action)
+			}
+			return
+		}
+		r.redact(m.Mutable(fd).Message(), path[1:], action)
+		return
+	}
+	if action == ActionDrop {
+		m.Clear(fd)
+		return
+	}
+	if fd.IsList() {
+		l := m.Mutable(fd).List()
+		for i := 0; i < l.Len(); i++ {
+			l.Set(i, r.redactValue(fd, l.Get(i), action))
+		}
+		return
+	}
+	m.Set(fd, r.redactValue(fd, m.Get(fd), action))
+}
+
+func (r *Redactor) redactValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, action Action) protoreflect.Value {
+	if fd.Kind() == protoreflect.StringKind {
+		s := v.String()
+		if action == ActionMask {
+			return protoreflect.ValueOfString(strings.Repeat("*", utf8.RuneCountInString(s)))
+		}
+		return protoreflect.ValueOfString(hex.EncodeToString(r.hash([]byte(s))))
+	}
+	b := v.Bytes()
+	if action == ActionMask {
+		return protoreflect.ValueOfBytes([]byte(strings.Repeat("*", len(b))))
+	}
+	return protoreflect.ValueOfBytes(r.hash(b))
+}
+
+func (r *Redactor) hash(b []byte) []byte {
+	mac := hmac.New(sha256.New, r.key.Load().([]byte))
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// redactEvent returns the bytes of the event with its fields redacted, false when its type has no field to redact.
+func (r *Redactor) redactEvent(e *pb.Event) ([]byte, bool, error) {
+	fields, ok := r.fields[e.Type]
+	if !ok {
+		return nil, false, nil
+	}
+	md, _ := r.registry.Message(e.Type)
+	m := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(e.EventBytes, m); err != nil {
+		return nil, true, err
+	}
+	for _, f := range fields {
+		r.redact(m, f.path, f.action)
+	}
+	b, err := proto.MarshalOptions{AllowPartial: true, Deterministic: true}.Marshal(m)
+	return b, true, err
+}
+
+// Middleware redacts the events of every request. As the fields cannot be redacted out of an event which does not
+// parse as its message, its request fails with an error wrapping collection.ErrInvalidRequest.
+func (r *Redactor) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		events := make([]*pb.Event, len(req.GetEvents()))
+		for i, e := range req.GetEvents() {
+			b, ok, err := r.redactEvent(e)
+			if err != nil {
+				metrics.Increment("events_redaction_failed_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
+				return fmt.Errorf("%w: event %d of type %s cannot be redacted: %v", collection.ErrInvalidRequest, i, e.Type, err)
+			}
+			if !ok {
+				events[i] = e
+				continue
+			}
+			metrics.Increment("events_redacted_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
+			events[i] = &pb.Event{EventBytes: b, Type: e.Type}
+			req.MoveEventHeaders(e, events[i])
+		}
+		req.Events = events
+		return next.Collect(ctx, req)
+	})
+}
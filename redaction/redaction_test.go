@@ -0,0 +1,193 @@
+package redaction
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// registry maps the signup type to the test.Signup message, which has an email, a phone, an age and contacts, each
+// contact having an email and a raw payload.
+func registry(t *testing.T) *schema.Registry {
+	str, byt, i32 := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum(), descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Contact"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("email"), Number: proto.Int32(1), Type: str},
+				{Name: proto.String("raw"), Number: proto.Int32(2), Type: byt},
+			},
+		}, {
+			Name: proto.String("Signup"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("email"), Number: proto.Int32(1), Type: str},
+				{Name: proto.String("phone"), Number: proto.Int32(2), Type: str},
+				{Name: proto.String("age"), Number: proto.Int32(3), Type: i32},
+				{Name: proto.String("contacts"), Number: proto.Int32(4), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Contact"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+			},
+		}},
+	}}}
+	r, err := schema.New(set, map[string]string{"signup": "test.Signup"})
+	require.NoError(t, err)
+	return r
+}
+
+func writeKey(t *testing.T, path, key string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(key+"\n"), 0600))
+}
+
+func tempKeyFile(t *testing.T, key string) string {
+	dir, err := ioutil.TempDir("", "redaction")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "key")
+	writeKey(t, path, key)
+	return path
+}
+
+func hmacOf(key, value string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// signup returns the bytes of a signup event.
+func signup(t *testing.T, r *schema.Registry) []byte {
+	md, _ := r.Message("signup")
+	m := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	m.Set(fields.ByName("email"), protoreflect.ValueOfString("jane@example.com"))
+	m.Set(fields.ByName("phone"), protoreflect.ValueOfString("+6281234"))
+	m.Set(fields.ByName("age"), protoreflect.ValueOfInt32(30))
+	contacts := m.Mutable(fields.ByName("contacts")).List()
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		contact := contacts.NewElement().Message()
+		contact.Set(contact.Descriptor().Fields().ByName("email"), protoreflect.ValueOfString(email))
+		contact.Set(contact.Descriptor().Fields().ByName("raw"), protoreflect.ValueOfBytes([]byte("raw")))
+		contacts.Append(protoreflect.ValueOfMessage(contact))
+	}
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func unmarshal(t *testing.T, r *schema.Registry, b []byte) protoreflect.Message {
+	md, _ := r.Message("signup")
+	m := dynamicpb.NewMessage(md)
+	require.NoError(t, proto.Unmarshal(b, m))
+	return m
+}
+
+func requestOf(events ...*pb.Event) *collection.CollectRequest {
+	return &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: "viewer"},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc", Events: events},
+	}
+}
+
+func TestNew(t *testing.T) {
+	r := registry(t)
+	key := tempKeyFile(t, "secret")
+
+	_, err := New(r, map[string]map[string]string{"signup": {"email": "hash", "contacts.raw": "mask", "age": "drop"}}, key)
+	assert.NoError(t, err)
+	for _, fields := range []map[string]map[string]string{
+		{"login": {"email": "drop"}},
+		{"signup": {"name": "drop"}},
+		{"signup": {"email.domain": "drop"}},
+		{"signup": {"age": "mask"}},
+		{"signup": {"contacts": "hash"}},
+		{"signup": {"email": "encrypt"}},
+	} {
+		_, err := New(r, fields, key)
+		assert.Error(t, err, "%v", fields)
+	}
+	_, err = New(r, map[string]map[string]string{"signup": {"email": "hash"}}, "")
+	assert.Error(t, err)
+	_, err = New(r, map[string]map[string]string{"signup": {"email": "mask"}}, "")
+	assert.NoError(t, err)
+}
+
+func TestRedactor_Middleware(t *testing.T) {
+	ctx := context.Background()
+	r := registry(t)
+	md, _ := r.Message("signup")
+	fields := md.Fields()
+	contactFields := fields.ByName("contacts").Message().Fields()
+
+	t.Run("Should redact the fields before collecting the events", func(t *testing.T) {
+		red, err := New(r, map[string]map[string]string{"signup": {"email": "hash", "phone": "mask", "age": "drop", "contacts.email": "hash", "contacts.raw": "mask"}}, tempKeyFile(t, "secret"))
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		b := signup(t, r)
+		req := requestOf(&pb.Event{EventBytes: b, Type: "signup"}, &pb.Event{EventBytes: []byte("untouched"), Type: "click"})
+
+		assert.NoError(t, red.Middleware(c).Collect(ctx, req))
+		require.Len(t, req.Events, 2)
+		assert.Equal(t, []byte("untouched"), req.Events[1].EventBytes)
+		m := unmarshal(t, r, req.Events[0].EventBytes)
+		assert.Equal(t, hex.EncodeToString(hmacOf("secret", "jane@example.com")), m.Get(fields.ByName("email")).String())
+		assert.Equal(t, "********", m.Get(fields.ByName("phone")).String())
+		assert.False(t, m.Has(fields.ByName("age")))
+		contacts := m.Get(fields.ByName("contacts")).List()
+		require.Equal(t, 2, contacts.Len())
+		assert.Equal(t, hex.EncodeToString(hmacOf("secret", "b@example.com")), contacts.Get(1).Message().Get(contactFields.ByName("email")).String())
+		assert.Equal(t, []byte("***"), contacts.Get(0).Message().Get(contactFields.ByName("raw")).Bytes())
+	})
+
+	t.Run("Should hash with the rotated key", func(t *testing.T) {
+		path := tempKeyFile(t, "secret")
+		red, err := New(r, map[string]map[string]string{"signup": {"email": "hash"}}, path)
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go red.WatchKey(watchCtx, time.Millisecond)
+		writeKey(t, path, "rotated")
+		assert.Eventually(t, func() bool {
+			b := signup(t, r)
+			req := requestOf(&pb.Event{EventBytes: b, Type: "signup"})
+			require.NoError(t, red.Middleware(c).Collect(ctx, req))
+			return unmarshal(t, r, req.Events[0].EventBytes).Get(fields.ByName("email")).String() == hex.EncodeToString(hmacOf("rotated", "jane@example.com"))
+		}, time.Second, time.Millisecond)
+
+		writeKey(t, path, "")
+		changed, err := red.ReloadKey()
+		assert.Error(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("Should reject a request with an event which cannot be redacted", func(t *testing.T) {
+		red, err := New(r, map[string]map[string]string{"signup": {"email": "drop"}}, "")
+		require.NoError(t, err)
+		c := &collection.MockCollector{}
+		err = red.Middleware(c).Collect(ctx, requestOf(&pb.Event{EventBytes: []byte{0x0a, 0x05, 'a'}, Type: "signup"}))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+}
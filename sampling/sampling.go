@@ -0,0 +1,80 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+)
+
+// All is the group or the type of the rates applying to every connection group or every event type.
+const All = "*"
+
+// Sampler is a collector stage keeping a fraction of the events of each type. Whether an event is kept only depends
+// on its connection, so that the whole stream of a sampled connection is kept.
+type Sampler struct {
+	// rates maps the groups, then the types, to the fraction of events kept
+	rates map[string]map[string]float64
+}
+
+// New creates a sampler keeping the fraction of events in rates, keyed by group then type, either being All. The
+// rate of a group and type takes precedence over the rate of the group for every type, then over the rate of the
+// type for every group. Every event is kept when no rate applies.
+func New(rates map[string]map[string]float64) *Sampler {
+	return &Sampler{rates: rates}
+}
+
+// Rate returns the fraction of the events of eventType from group which are kept.
+func (s *Sampler) Rate(group, eventType string) float64 {
+	for _, key := range [][2]string{{group, eventType}, {group, All}, {All, eventType}, {All, All}} {
+		if rate, ok := s.rates[key[0]][key[1]]; ok {
+			return rate
+		}
+	}
+	return 1
+}
+
+// keep tells whether the events of the connection are kept at rate.
+func keep(connID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(connID))
+	// FNV spreads similar IDs poorly over the high bits, the finalizer of murmur3 mixes them
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return float64(x>>11) < rate*(1<<53)
+}
+
+// Middleware removes the events which are not sampled from every request.
+func (s *Sampler) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		var events []*pb.Event
+		sampled := false
+		for _, e := range req.GetEvents() {
+			if keep(req.ConnectionIdentifier.ID, s.Rate(group, e.Type)) {
+				events = append(events, e)
+				continue
+			}
+			sampled = true
+			metrics.Increment("events_sampled_out_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
+		}
+		if !sampled {
+			return next.Collect(ctx, req)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		req.Events = events
+		return next.Collect(ctx, req)
+	})
+}
@@ -0,0 +1,84 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func requestOf(connID, group string, types ...string) *collection.CollectRequest {
+	req := &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: connID, Group: group},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc"},
+	}
+	for _, t := range types {
+		req.Events = append(req.Events, &pb.Event{EventBytes: []byte(t), Type: t})
+	}
+	return req
+}
+
+func TestSampler_Rate(t *testing.T) {
+	s := New(map[string]map[string]float64{
+		"viewer": {"scroll": 0.1, All: 0.5},
+		All:      {"scroll": 0.2, "debug": 0.01},
+	})
+
+	assert.Equal(t, 0.1, s.Rate("viewer", "scroll"))
+	assert.Equal(t, 0.5, s.Rate("viewer", "debug"))
+	assert.Equal(t, 0.2, s.Rate("driver", "scroll"))
+	assert.Equal(t, 0.01, s.Rate("driver", "debug"))
+	assert.Equal(t, 1.0, s.Rate("driver", "click"))
+	assert.Equal(t, 0.5, New(map[string]map[string]float64{All: {All: 0.5}}).Rate("driver", "click"))
+}
+
+func TestSampler_Middleware(t *testing.T) {
+	ctx := context.Background()
+	s := New(map[string]map[string]float64{All: {"scroll": 0.25, "debug": 0}})
+
+	t.Run("Should keep the whole stream of the sampled connections", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		m := s.Middleware(c)
+
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			connID := fmt.Sprintf("conn-%d", i)
+			for j := 0; j < 3; j++ {
+				assert.NoError(t, m.Collect(ctx, requestOf(connID, "viewer", "click", "scroll", "debug")))
+			}
+			calls := c.Calls[len(c.Calls)-3:]
+			events := len(calls[0].Arguments.Get(1).(*collection.CollectRequest).Events)
+			for _, call := range calls {
+				assert.Len(t, call.Arguments.Get(1).(*collection.CollectRequest).Events, events)
+			}
+			if events == 2 {
+				kept++
+			} else {
+				assert.Equal(t, 1, events)
+			}
+		}
+		c.AssertNumberOfCalls(t, "Collect", 3000)
+		assert.InDelta(t, 250, kept, 50)
+	})
+
+	t.Run("Should not collect a request whose events are all sampled out", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		assert.NoError(t, s.Middleware(c).Collect(ctx, requestOf("conn", "viewer", "debug")))
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should collect every event when no rate applies", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		req := requestOf("conn", "viewer", "click", "booking")
+		c.On("Collect", ctx, req).Return(nil)
+		assert.NoError(t, New(nil).Middleware(c).Collect(ctx, req))
+		assert.Len(t, req.Events, 2)
+		c.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Registry maps the event types to the protobuf message of their bytes.
+type Registry struct {
+	messages map[string]protoreflect.MessageDescriptor
+}
+
+// Load creates a registry out of the FileDescriptorSet at path, as written by protoc --descriptor_set_out with
+// --include_imports. messages maps the event types to the full name of their message.
+func Load(path string, messages map[string]string) (*Registry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("%s is not a FileDescriptorSet: %w", path, err)
+	}
+	return New(set, messages)
+}
+
+// New creates a registry out of the descriptors of set. messages maps the event types to the full name of their
+// message.
+func New(set *descriptorpb.FileDescriptorSet, messages map[string]string) (*Registry, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptors: %w", err)
+	}
+	r := &Registry{messages: make(map[string]protoreflect.MessageDescriptor, len(messages))}
+	for eventType, name := range messages {
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("message %s of event type %s: %w", name, eventType, err)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s of event type %s is not a message", name, eventType)
+		}
+		r.messages[eventType] = md
+	}
+	return r, nil
+}
+
+// Message returns the message of the events of eventType, false when the type has none.
+func (r *Registry) Message(eventType string) (protoreflect.MessageDescriptor, bool) {
+	md, ok := r.messages[eventType]
+	return md, ok
+}
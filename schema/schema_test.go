@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var set = &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+	Name:    proto.String("test.proto"),
+	Package: proto.String("test"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{{
+		Name:  proto.String("Click"),
+		Field: []*descriptorpb.FieldDescriptorProto{{Name: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()}},
+	}},
+}}}
+
+func TestLoad(t *testing.T) {
+	b, err := proto.Marshal(set)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "schema")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "descriptors.pb")
+	require.NoError(t, ioutil.WriteFile(path, b, 0644))
+
+	r, err := Load(path, map[string]string{"click": "test.Click"})
+	require.NoError(t, err)
+	md, ok := r.Message("click")
+	assert.True(t, ok)
+	assert.Equal(t, "test.Click", string(md.FullName()))
+	_, ok = r.Message("scroll")
+	assert.False(t, ok)
+
+	_, err = Load(path, map[string]string{"click": "test.Scroll"})
+	assert.Error(t, err)
+	_, err = Load(path, map[string]string{"click": "test.Click.id"})
+	assert.Error(t, err)
+	_, err = Load(filepath.Join(dir, "missing.pb"), nil)
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a descriptor set"), 0644))
+	_, err = Load(path, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/odpf/raccoon/filter"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/worker"
+)
+
+// WorkerPool is the worker pool as controlled by the admin endpoint.
+type WorkerPool interface {
+	Pause() error
+	Resume() error
+	Drain(timeout time.Duration) bool
+	Status() worker.Status
+}
+
+// EventTypes is the kill switch of the event types as controlled by the admin endpoint.
+type EventTypes interface {
+	Disable(eventType string, action filter.Action)
+	Enable(eventType string)
+	Disabled() map[string]filter.Action
+}
+
+type Handler struct {
+	pool       WorkerPool
+	eventTypes EventTypes
+	// drainTimeout bounds the drain when the request does not set timeout_ms
+	drainTimeout time.Duration
+}
+
+func NewHandler(pool WorkerPool, eventTypes EventTypes, drainTimeout time.Duration) *Handler {
+	return &Handler{
+		pool:         pool,
+		eventTypes:   eventTypes,
+		drainTimeout: drainTimeout,
+	}
+}
+
+type drainResponse struct {
+	worker.Status
+	TimedOut bool `json:"timed_out"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) StatusHandler(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, h.pool.Status())
+}
+
+func (h *Handler) PauseHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Info("[admin] pausing workers")
+	if err := h.pool.Pause(); err != nil {
+		writeJSON(rw, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, h.pool.Status())
+}
+
+func (h *Handler) ResumeHandler(rw http.ResponseWriter, r *http.Request) {
+	logger.Info("[admin] resuming workers")
+	if err := h.pool.Resume(); err != nil {
+		writeJSON(rw, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, h.pool.Status())
+}
+
+// DrainHandler publishes the buffered events then stops the workers, which can no longer be resumed. The drain is bounded by the timeout_ms query
+// parameter when set.
+func (h *Handler) DrainHandler(rw http.ResponseWriter, r *http.Request) {
+	timeout := h.drainTimeout
+	if v := r.URL.Query().Get("timeout_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			writeJSON(rw, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid timeout_ms %q", v)})
+			return
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	logger.Info(fmt.Sprintf("[admin] draining workers within %v", timeout))
+	timedOut := h.pool.Drain(timeout)
+	writeJSON(rw, http.StatusOK, drainResponse{Status: h.pool.Status(), TimedOut: timedOut})
+}
+
+func (h *Handler) DisabledEventTypesHandler(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, h.eventTypes.Disabled())
+}
+
+// DisableEventTypeHandler stops accepting the events of a type. The action query parameter overrides the action of
+// the filter.
+func (h *Handler) DisableEventTypeHandler(rw http.ResponseWriter, r *http.Request) {
+	eventType := mux.Vars(r)["type"]
+	action := filter.Action(r.URL.Query().Get("action"))
+	switch action {
+	case "", filter.ActionDrop, filter.ActionReject, filter.ActionQuarantine:
+	default:
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid action %q", action)})
+		return
+	}
+	logger.Info(fmt.Sprintf("[admin] disabling event type %s", eventType))
+	h.eventTypes.Disable(eventType, action)
+	writeJSON(rw, http.StatusOK, h.eventTypes.Disabled())
+}
+
+func (h *Handler) EnableEventTypeHandler(rw http.ResponseWriter, r *http.Request) {
+	eventType := mux.Vars(r)["type"]
+	logger.Info(fmt.Sprintf("[admin] enabling event type %s", eventType))
+	h.eventTypes.Enable(eventType)
+	writeJSON(rw, http.StatusOK, h.eventTypes.Disabled())
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		logger.Errorf("[admin] error sending response: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/odpf/raccoon/filter"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type void struct{}
+
+func (v void) Write(_ []byte) (int, error) {
+	return 0, nil
+}
+
+type mockWorkerPool struct {
+	mock.Mock
+}
+
+func (m *mockWorkerPool) Pause() error {
+	return m.Called().Error(0)
+}
+
+func (m *mockWorkerPool) Resume() error {
+	return m.Called().Error(0)
+}
+
+func (m *mockWorkerPool) Drain(timeout time.Duration) bool {
+	return m.Called(timeout).Bool(0)
+}
+
+func (m *mockWorkerPool) Status() worker.Status {
+	return m.Called().Get(0).(worker.Status)
+}
+
+func TestHandler(t *testing.T) {
+	logger.SetOutput(void{})
+	paused := worker.Status{State: worker.StatePaused, Workers: 4, Buffered: 10}
+
+	t.Run("Should return the status of the workers", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		pool.On("Status").Return(paused)
+		rec := httptest.NewRecorder()
+		NewHandler(pool, nil, time.Second).StatusHandler(rec, httptest.NewRequest(http.MethodGet, "/workers", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"state":"paused","workers":4,"priority_workers":0,"buffered":10,"busy":0}`, rec.Body.String())
+	})
+
+	t.Run("Should pause and resume the workers", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		pool.On("Pause").Return(nil)
+		pool.On("Resume").Return(nil)
+		pool.On("Status").Return(paused)
+		h := NewHandler(pool, nil, time.Second)
+		rec := httptest.NewRecorder()
+		h.PauseHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/pause", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		h.ResumeHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/workers/resume", nil))
+		pool.AssertNumberOfCalls(t, "Pause", 1)
+		pool.AssertNumberOfCalls(t, "Resume", 1)
+	})
+
+	t.Run("Should answer conflict when the workers cannot be paused", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		pool.On("Pause").Return(worker.ErrPauseRefused)
+		h := NewHandler(pool, nil, time.Second)
+		rec := httptest.NewRecorder()
+		h.PauseHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/pause", nil))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), worker.ErrPauseRefused.Error())
+		pool.AssertNotCalled(t, "Status")
+	})
+
+	t.Run("Should refuse to resume the workers stopped by a drain", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		pool.On("Resume").Return(worker.ErrStopped)
+		rec := httptest.NewRecorder()
+		NewHandler(pool, nil, time.Second).ResumeHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/resume", nil))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"error":"worker pool is stopped by a drain"}`, rec.Body.String())
+	})
+
+	t.Run("Should drain the workers within the timeout", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		pool.On("Drain", time.Second).Return(false)
+		pool.On("Drain", 500*time.Millisecond).Return(true)
+		pool.On("Status").Return(worker.Status{State: worker.StateStopped, Workers: 4, Buffered: 10})
+		h := NewHandler(pool, nil, time.Second)
+
+		rec := httptest.NewRecorder()
+		h.DrainHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/drain", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"state":"stopped","workers":4,"priority_workers":0,"buffered":10,"busy":0,"timed_out":false}`, rec.Body.String())
+
+		rec = httptest.NewRecorder()
+		h.DrainHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/drain?timeout_ms=500", nil))
+		assert.JSONEq(t, `{"state":"stopped","workers":4,"priority_workers":0,"buffered":10,"busy":0,"timed_out":true}`, rec.Body.String())
+	})
+
+	t.Run("Should reject an invalid timeout", func(t *testing.T) {
+		pool := &mockWorkerPool{}
+		rec := httptest.NewRecorder()
+		NewHandler(pool, nil, time.Second).DrainHandler(rec, httptest.NewRequest(http.MethodPost, "/workers/drain?timeout_ms=abc", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		pool.AssertNotCalled(t, "Drain", mock.Anything)
+	})
+}
+
+func TestHandler_EventTypes(t *testing.T) {
+	logger.SetOutput(void{})
+	eventTypes := filter.New(filter.Rules{}, filter.ActionDrop, "quarantine")
+	router := mux.NewRouter()
+	h := NewHandler(&mockWorkerPool{}, eventTypes, time.Second)
+	router.Path("/event-types/disabled").HandlerFunc(h.DisabledEventTypesHandler)
+	router.Path("/event-types/{type}/disable").HandlerFunc(h.DisableEventTypeHandler)
+	router.Path("/event-types/{type}/enable").HandlerFunc(h.EnableEventTypeHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-types/click/disable", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"click":"drop"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-types/scroll/disable?action=reject", nil))
+	assert.JSONEq(t, `{"click":"drop","scroll":"reject"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-types/scroll/disable?action=ignore", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/event-types/click/enable", nil))
+	assert.JSONEq(t, `{"scroll":"reject"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/event-types/disabled", nil))
+	assert.JSONEq(t, `{"scroll":"reject"}`, rec.Body.String())
+}
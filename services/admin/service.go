@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/odpf/raccoon/config"
+)
+
+// Service serves the operations controlling the running server. It listens on SERVER_ADMIN_ADDR which should not be
+// reachable by the clients.
+type Service struct {
+	s *http.Server
+}
+
+// NewAdminService creates the admin service. The event types routes are served when eventTypes is not nil.
+func NewAdminService(pool WorkerPool, eventTypes EventTypes) *Service {
+	h := NewHandler(pool, eventTypes, config.Worker.WorkerFlushTimeout)
+	router := mux.NewRouter()
+	router.Path("/workers").HandlerFunc(h.StatusHandler).Methods(http.MethodGet)
+	router.Path("/workers/pause").HandlerFunc(h.PauseHandler).Methods(http.MethodPost)
+	router.Path("/workers/resume").HandlerFunc(h.ResumeHandler).Methods(http.MethodPost)
+	router.Path("/workers/drain").HandlerFunc(h.DrainHandler).Methods(http.MethodPost)
+	if eventTypes != nil {
+		router.Path("/event-types/disabled").HandlerFunc(h.DisabledEventTypesHandler).Methods(http.MethodGet)
+		router.Path("/event-types/{type}/disable").HandlerFunc(h.DisableEventTypeHandler).Methods(http.MethodPost)
+		router.Path("/event-types/{type}/enable").HandlerFunc(h.EnableEventTypeHandler).Methods(http.MethodPost)
+	}
+	return &Service{
+		s: &http.Server{Addr: config.ServerAdmin.Addr, Handler: router},
+	}
+}
+
+func (s *Service) Init(context.Context) error {
+	return s.s.ListenAndServe()
+}
+
+func (*Service) Name() string {
+	return "admin"
+}
+
+func (s *Service) Shutdown(ctx context.Context) error {
+	return s.s.Shutdown(ctx)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/odpf/raccoon/collection"
@@ -11,7 +12,11 @@ import (
 	"github.com/odpf/raccoon/identification"
 	"github.com/odpf/raccoon/metrics"
 	pb "github.com/odpf/raccoon/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type Handler struct {
@@ -46,13 +51,16 @@ func (h *Handler) SendEvent(ctx context.Context, req *pb.SendEventRequest) (*pb.
 	timeConsumed := time.Now()
 
 	metrics.Increment("batches_read_total", fmt.Sprintf("status=success,conn_group=%s", identifier.Group))
-	h.sendEventCounters(req.Events, identifier.Group)
 
-	h.C.Collect(ctx, &collection.CollectRequest{
+	err := h.C.Collect(ctx, &collection.CollectRequest{
 		ConnectionIdentifier: identifier,
+		Client:               newClient(ctx, metadata),
 		TimeConsumed:         timeConsumed,
 		SendEventRequest:     req,
 	})
+	if err != nil {
+		return nil, failureStatus(req.GetReqGuid(), err).Err()
+	}
 
 	return &pb.SendEventResponse{
 		Status:   pb.Status_STATUS_SUCCESS,
@@ -65,8 +73,42 @@ func (h *Handler) SendEvent(ctx context.Context, req *pb.SendEventRequest) (*pb.
 
 }
 
-func (h *Handler) sendEventCounters(events []*pb.Event, group string) {
-	for _, e := range events {
-		metrics.Increment("events_rx_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
+// newClient returns the client of a call from its peer and metadata.
+func newClient(ctx context.Context, md metadata.MD) identification.Client {
+	client := identification.Client{
+		ForwardedFor: strings.Join(md.Get("x-forwarded-for"), ", "),
+		UserAgent:    strings.Join(md.Get("user-agent"), " "),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		client.RemoteAddr = p.Addr.String()
+	}
+	return client
+}
+
+// failureStatus returns the status of the request which failed with err. When some of its events were collected
+// anyway, the status has an ErrorInfo detail whose metadata is the data of the REST and websocket responses, telling
+// their indexes in collected_events.
+func failureStatus(reqGuid string, err error) *status.Status {
+	var s *status.Status
+	switch {
+	case collection.Retryable(err):
+		s = status.New(codes.Unavailable, err.Error())
+	case errors.Is(err, collection.ErrInvalidRequest):
+		s = status.New(codes.InvalidArgument, err.Error())
+	default:
+		s = status.FromContextError(err)
+	}
+	var partial *collection.PartialError
+	if !errors.As(err, &partial) {
+		return s
+	}
+	detailed, derr := s.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "PARTIALLY_COLLECTED",
+		Domain:   "raccoon",
+		Metadata: collection.FailureData(reqGuid, err),
+	})
+	if derr != nil {
+		return s
 	}
+	return detailed
 }
@@ -2,6 +2,7 @@ package grpc
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -10,8 +11,13 @@ import (
 	"github.com/odpf/raccoon/logger"
 	"github.com/odpf/raccoon/metrics"
 	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -52,12 +58,28 @@ func TestHandler_SendEvent(t *testing.T) {
 	contextWithoutGroup := metadata.NewIncomingContext(ctx, metaWithoutGroup)
 	collector.On("Collect", contextWithoutGroup, mock.Anything).Return(nil)
 
+	metaOfFullBuffer := metadata.MD{}
+	metaOfFullBuffer.Set(config.ServerWs.ConnIDHeader, "5678")
+	contextOfFullBuffer := metadata.NewIncomingContext(ctx, metaOfFullBuffer)
+	collector.On("Collect", contextOfFullBuffer, mock.Anything).Return(collection.ErrBufferFull)
+
+	metaOfInProgress := metadata.MD{}
+	metaOfInProgress.Set(config.ServerWs.ConnIDHeader, "3456")
+	contextOfInProgress := metadata.NewIncomingContext(ctx, metaOfInProgress)
+	collector.On("Collect", contextOfInProgress, mock.Anything).Return(fmt.Errorf("%w: request with the same ReqGuid is being collected", collection.ErrUnavailable))
+
+	metaOfRejected := metadata.MD{}
+	metaOfRejected.Set(config.ServerWs.ConnIDHeader, "9012")
+	contextOfRejected := metadata.NewIncomingContext(ctx, metaOfRejected)
+	collector.On("Collect", contextOfRejected, mock.Anything).Return(fmt.Errorf("%w: event type debug is not accepted", collection.ErrInvalidRequest))
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *pb.SendEventResponse
-		wantErr bool
+		name     string
+		fields   fields
+		args     args
+		want     *pb.SendEventResponse
+		wantErr  bool
+		wantCode codes.Code
 	}{
 		{
 			name: "Sending normal event",
@@ -95,6 +117,42 @@ func TestHandler_SendEvent(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Sending when the buffer is full",
+			fields: fields{
+				C: collector,
+			},
+			args: args{
+				ctx: contextOfFullBuffer,
+				req: req,
+			},
+			wantErr:  true,
+			wantCode: codes.Unavailable,
+		},
+		{
+			name: "Sending while the original request is being collected",
+			fields: fields{
+				C: collector,
+			},
+			args: args{
+				ctx: contextOfInProgress,
+				req: req,
+			},
+			wantErr:  true,
+			wantCode: codes.Unavailable,
+		},
+		{
+			name: "Sending a request which is not accepted",
+			fields: fields{
+				C: collector,
+			},
+			args: args{
+				ctx: contextOfRejected,
+				req: req,
+			},
+			wantErr:  true,
+			wantCode: codes.InvalidArgument,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -106,9 +164,30 @@ func TestHandler_SendEvent(t *testing.T) {
 				t.Errorf("Handler.SendEvent() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr && status.Code(err) != tt.wantCode {
+				t.Errorf("Handler.SendEvent() code = %v, want %v", status.Code(err), tt.wantCode)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Handler.SendEvent() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestFailureStatus(t *testing.T) {
+	t.Run("Should tell the events collected anyway in the details", func(t *testing.T) {
+		s := failureStatus("abcd", &collection.PartialError{Collected: []int{0, 2}, Err: collection.ErrBufferFull})
+		assert.Equal(t, codes.Unavailable, s.Code())
+		require.Len(t, s.Details(), 1)
+		info, ok := s.Details()[0].(*errdetails.ErrorInfo)
+		require.True(t, ok)
+		assert.Equal(t, "PARTIALLY_COLLECTED", info.Reason)
+		assert.Equal(t, map[string]string{"req_guid": "abcd", "retryable": "true", "collected_events": "0,2"}, info.Metadata)
+	})
+
+	t.Run("Should not add details when no event was collected", func(t *testing.T) {
+		s := failureStatus("abcd", collection.ErrBufferFull)
+		assert.Equal(t, codes.Unavailable, s.Code())
+		assert.Empty(t, s.Details())
+	})
+}
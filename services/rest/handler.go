@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -125,13 +126,30 @@ func (h *Handler) RESTAPIHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	metrics.Increment("batches_read_total", fmt.Sprintf("status=success,conn_group=%s", identifier.Group))
-	h.sendEventCounters(req.Events, identifier.Group)
 
-	h.collector.Collect(r.Context(), &collection.CollectRequest{
+	err = h.collector.Collect(r.Context(), &collection.CollectRequest{
 		ConnectionIdentifier: identifier,
+		Client:               identification.NewClient(r),
 		TimeConsumed:         timeConsumed,
 		SendEventRequest:     req,
 	})
+	if err != nil {
+		logger.Errorf("[rest.GetRESTAPIHandler] %s failed to collect request %s: %v", identifier, req.ReqGuid, err)
+		status, code := http.StatusInternalServerError, pb.Code_CODE_INTERNAL_ERROR
+		if collection.Retryable(err) {
+			status = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, collection.ErrInvalidRequest) {
+			status, code = http.StatusBadRequest, pb.Code_CODE_BAD_REQUEST
+		}
+		rw.WriteHeader(status)
+		_, err := res.SetCode(code).SetStatus(pb.Status_STATUS_ERROR).SetReason(err.Error()).
+			SetSentTime(time.Now().Unix()).SetDataMap(collection.FailureData(req.ReqGuid, err)).Write(rw, s)
+		if err != nil {
+			logger.Errorf("[restGetRESTAPIHandler] %s error sending error response: %v", identifier, err)
+		}
+		return
+	}
 
 	_, err = res.SetCode(pb.Code_CODE_OK).SetStatus(pb.Status_STATUS_SUCCESS).SetSentTime(time.Now().Unix()).
 		SetDataMap(map[string]string{"req_guid": req.ReqGuid}).Write(rw, s)
@@ -139,10 +157,3 @@ func (h *Handler) RESTAPIHandler(rw http.ResponseWriter, r *http.Request) {
 		logger.Errorf("[restGetRESTAPIHandler] %s error sending error response: %v", identifier, err)
 	}
 }
-
-func (h *Handler) sendEventCounters(events []*pb.Event, group string) {
-	for _, e := range events {
-		metrics.Count("events_rx_bytes_total", len(e.EventBytes), fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
-		metrics.Increment("events_rx_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
-	}
-}
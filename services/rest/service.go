@@ -32,6 +32,9 @@ func NewRestService(c collection.Collector) *Service {
 	subRouter := router.PathPrefix("/api/v1").Subrouter()
 	subRouter.HandleFunc("/events", wh.HandlerWSEvents).Methods(http.MethodGet).Name("events")
 	subRouter.HandleFunc("/events", restHandler.RESTAPIHandler).Methods(http.MethodPost).Name("events")
+	if config.ServerWs.TimeEndpoint {
+		subRouter.HandleFunc("/time", timeHandler).Methods(http.MethodGet).Name("time")
+	}
 
 	server := &http.Server{
 		Handler: router,
@@ -0,0 +1,31 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// timeResponse is the time of the server in Unix milliseconds. ClientTime echoes the client_time query parameter, for
+// a client to estimate the offset of its clock as ServerTime minus the midpoint of ClientTime and the time it got the
+// response.
+type timeResponse struct {
+	ServerTime int64  `json:"server_time"`
+	ClientTime *int64 `json:"client_time,omitempty"`
+}
+
+func timeHandler(w http.ResponseWriter, r *http.Request) {
+	res := timeResponse{ServerTime: time.Now().UnixNano() / int64(time.Millisecond)}
+	if param := r.URL.Query().Get("client_time"); param != "" {
+		clientTime, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			http.Error(w, "client_time should be in Unix milliseconds", http.StatusBadRequest)
+			return
+		}
+		res.ClientTime = &clientTime
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(res)
+}
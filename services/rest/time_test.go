@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeHandler(t *testing.T) {
+	t.Run("Should return the server time and echo the client time", func(t *testing.T) {
+		before := time.Now().UnixNano() / int64(time.Millisecond)
+		rr := httptest.NewRecorder()
+		timeHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/time?client_time=1600000000000", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+		var res timeResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		assert.GreaterOrEqual(t, res.ServerTime, before)
+		assert.LessOrEqual(t, res.ServerTime, time.Now().UnixNano()/int64(time.Millisecond))
+		assert.Equal(t, int64(1600000000000), *res.ClientTime)
+	})
+
+	t.Run("Should omit the client time when not given", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		timeHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/time", nil))
+		assert.NotContains(t, rr.Body.String(), "client_time")
+	})
+
+	t.Run("Should reject a malformed client time", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		timeHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/time?client_time=now", nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
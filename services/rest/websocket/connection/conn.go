@@ -12,6 +12,7 @@ import (
 
 type Conn struct {
 	Identifier  identification.Identifier
+	Client      identification.Client
 	conn        *websocket.Conn
 	connectedAt time.Time
 	closeHook   func(c Conn)
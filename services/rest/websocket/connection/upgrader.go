@@ -92,6 +92,7 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (Conn, error)
 
 	return Conn{
 		Identifier:  identifier,
+		Client:      identification.NewClient(r),
 		conn:        conn,
 		connectedAt: time.Now(),
 		closeHook: func(c Conn) {
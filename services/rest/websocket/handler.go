@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -103,24 +104,22 @@ func (h *Handler) HandlerWSEvents(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		metrics.Increment("batches_read_total", fmt.Sprintf("status=success,conn_group=%s", conn.Identifier.Group))
-		h.sendEventCounters(payload.Events, conn.Identifier.Group)
 
-		h.collector.Collect(r.Context(), &collection.CollectRequest{
+		err = h.collector.Collect(r.Context(), &collection.CollectRequest{
 			ConnectionIdentifier: conn.Identifier,
+			Client:               conn.Client,
 			TimeConsumed:         timeConsumed,
 			SendEventRequest:     payload,
 		})
+		if err != nil {
+			logger.Error(fmt.Sprintf("[websocket.Handler] failed to collect request %s of %s: %v", payload.ReqGuid, conn.Identifier, err))
+			writeCollectFailureResponse(conn, s, messageType, payload.ReqGuid, err)
+			continue
+		}
 		writeSuccessResponse(conn, s, messageType, payload.ReqGuid)
 	}
 }
 
-func (h *Handler) sendEventCounters(events []*pb.Event, group string) {
-	for _, e := range events {
-		metrics.Count("events_rx_bytes_total", len(e.EventBytes), fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
-		metrics.Increment("events_rx_total", fmt.Sprintf("conn_group=%s,event_type=%s", group, e.Type))
-	}
-}
-
 func writeSuccessResponse(conn connection.Conn, serialize serialization.SerializeFunc, messageType int, requestGUID string) {
 	response := &pb.SendEventResponse{
 		Status:   pb.Status_STATUS_SUCCESS,
@@ -147,3 +146,20 @@ func writeBadRequestResponse(conn connection.Conn, serialize serialization.Seria
 	failure, _ := serialize(response)
 	conn.WriteMessage(messageType, failure)
 }
+
+func writeCollectFailureResponse(conn connection.Conn, serialize serialization.SerializeFunc, messageType int, requestGUID string, err error) {
+	code := pb.Code_CODE_INTERNAL_ERROR
+	if errors.Is(err, collection.ErrInvalidRequest) {
+		code = pb.Code_CODE_BAD_REQUEST
+	}
+	response := &pb.SendEventResponse{
+		Status:   pb.Status_STATUS_ERROR,
+		Code:     code,
+		SentTime: time.Now().Unix(),
+		Reason:   err.Error(),
+		Data:     collection.FailureData(requestGUID, err),
+	}
+
+	failure, _ := serialize(response)
+	conn.WriteMessage(messageType, failure)
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 
 	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/config"
 	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/services/admin"
 	"github.com/odpf/raccoon/services/grpc"
 	"github.com/odpf/raccoon/services/pprof"
 	"github.com/odpf/raccoon/services/rest"
@@ -43,13 +45,14 @@ func (s *Services) Shutdown(ctx context.Context) {
 	}
 }
 
-func Create(b chan collection.CollectRequest) Services {
-	c := collection.NewChannelCollector(b)
-	return Services{
-		b: []bootstrapper{
-			grpc.NewGRPCService(c),
-			pprof.NewPprofService(),
-			rest.NewRestService(c),
-		},
+func Create(c collection.Collector, pool admin.WorkerPool, eventTypes admin.EventTypes) Services {
+	b := []bootstrapper{
+		grpc.NewGRPCService(c),
+		pprof.NewPprofService(),
+		rest.NewRestService(c),
 	}
+	if config.ServerAdmin.Addr != "" {
+		b = append(b, admin.NewAdminService(pool, eventTypes))
+	}
+	return Services{b: b}
 }
@@ -0,0 +1,92 @@
+package timeliness
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+)
+
+// Action tells what to do with a batch sent out of the accepted window.
+type Action string
+
+const (
+	// ActionFlag collects the batch, its records carrying HeaderTimeliness.
+	ActionFlag Action = "flag"
+	// ActionReroute publishes the events of the batch with the reroute type, so that they go to its topic, their
+	// records carrying HeaderTimeliness and collection.HeaderOriginalType.
+	ActionReroute Action = "reroute"
+	// ActionReject fails the batch with an error wrapping collection.ErrInvalidRequest.
+	ActionReject Action = "reject"
+)
+
+// HeaderTimeliness tells whether a batch was stale or future-dated.
+const HeaderTimeliness = "raccoon-timeliness"
+
+const (
+	stale  = "stale"
+	future = "future"
+)
+
+// Policy is what to do with the batches sent too long ago or too far in the future, according to their SentTime and
+// the time they were received.
+type Policy struct {
+	// MaxAge is how long ago a batch may have been sent, zero for no limit
+	MaxAge time.Duration
+	// StaleAction is applied to the batches sent more than MaxAge ago
+	StaleAction Action
+	// MaxAhead is how far in the future a batch may have been sent, zero for no limit
+	MaxAhead time.Duration
+	// FutureAction is applied to the batches sent more than MaxAhead in the future
+	FutureAction Action
+	// RerouteType is the type the events of rerouted batches are published with
+	RerouteType string
+}
+
+// check returns whether req is stale or future-dated, with the action to apply, false when it is timely.
+func (p Policy) check(req *collection.CollectRequest) (string, Action, time.Duration, bool) {
+	if req.GetSentTime() == nil || req.TimeConsumed.IsZero() {
+		return "", "", 0, false
+	}
+	age := req.TimeConsumed.Sub(req.GetSentTime().AsTime())
+	if p.MaxAge > 0 && age > p.MaxAge {
+		return stale, p.StaleAction, age, true
+	}
+	if p.MaxAhead > 0 && -age > p.MaxAhead {
+		return future, p.FutureAction, -age, true
+	}
+	return "", "", 0, false
+}
+
+// Middleware applies the policy to every request.
+func (p Policy) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		kind, action, by, ok := p.check(req)
+		if !ok {
+			return next.Collect(ctx, req)
+		}
+		group := req.ConnectionIdentifier.Group
+		metrics.Increment("untimely_batches_total", fmt.Sprintf("action=%s,conn_group=%s,timeliness=%s", action, group, kind))
+		switch action {
+		case ActionReject:
+			if kind == stale {
+				return fmt.Errorf("%w: batch sent %s ago, more than %s", collection.ErrInvalidRequest, by.Round(time.Second), p.MaxAge)
+			}
+			return fmt.Errorf("%w: batch sent %s in the future, more than %s", collection.ErrInvalidRequest, by.Round(time.Second), p.MaxAhead)
+		case ActionReroute:
+			events := make([]*pb.Event, len(req.GetEvents()))
+			for i, e := range req.GetEvents() {
+				events[i] = req.Retype(e, p.RerouteType)
+			}
+			req.Events = events
+		}
+		if req.Enrichment == nil {
+			req.Enrichment = make(map[string]string, 1)
+		}
+		req.Enrichment[HeaderTimeliness] = kind
+		return next.Collect(ctx, req)
+	})
+}
@@ -0,0 +1,103 @@
+package timeliness
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/clockskew"
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func requestSentAgo(age time.Duration, types ...string) *collection.CollectRequest {
+	now := time.Now()
+	req := &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: "viewer"},
+		TimeConsumed:         now,
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc", SentTime: timestamppb.New(now.Add(-age))},
+	}
+	for _, t := range types {
+		req.Events = append(req.Events, &pb.Event{EventBytes: []byte(t), Type: t})
+	}
+	return req
+}
+
+func TestPolicy_Middleware(t *testing.T) {
+	ctx := context.Background()
+	week := 7 * 24 * time.Hour
+	policy := Policy{
+		MaxAge:       24 * time.Hour,
+		StaleAction:  ActionReroute,
+		MaxAhead:     time.Hour,
+		FutureAction: ActionReject,
+		RerouteType:  "late",
+	}
+
+	t.Run("Should collect the timely batches untouched", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		for _, age := range []time.Duration{0, time.Hour, -time.Minute} {
+			req := requestSentAgo(age, "click")
+			assert.NoError(t, policy.Middleware(c).Collect(ctx, req))
+			assert.Equal(t, "click", req.Events[0].Type)
+			assert.Nil(t, req.Enrichment)
+		}
+		c.AssertNumberOfCalls(t, "Collect", 3)
+	})
+
+	t.Run("Should reroute the stale batches", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		req := requestSentAgo(week, "click", "scroll")
+		original := req.Events[0]
+		assert.NoError(t, policy.Middleware(c).Collect(ctx, req))
+		assert.Equal(t, []string{"late", "late"}, []string{req.Events[0].Type, req.Events[1].Type})
+		assert.Equal(t, []byte("scroll"), req.Events[1].EventBytes)
+		assert.Equal(t, "click", original.Type)
+		assert.Equal(t, map[string]string{HeaderTimeliness: "stale"}, req.Enrichment)
+		assert.Equal(t, map[string]string{HeaderTimeliness: "stale", collection.HeaderOriginalType: "click"}, req.Headers(req.Events[0]))
+		assert.Equal(t, map[string]string{HeaderTimeliness: "stale", collection.HeaderOriginalType: "scroll"}, req.Headers(req.Events[1]))
+		c.AssertNumberOfCalls(t, "Collect", 1)
+	})
+
+	t.Run("Should reject the future-dated batches", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		err := policy.Middleware(c).Collect(ctx, requestSentAgo(-week, "click"))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+		assert.Contains(t, err.Error(), "in the future")
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should apply to the sent time of skewed clocks after clock_skew", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		chain := collection.Chain(c, clockskew.New(time.Minute).Middleware, policy.Middleware)
+		req := requestSentAgo(week, "click")
+		assert.NoError(t, chain.Collect(ctx, req))
+		assert.Equal(t, "late", req.Events[0].Type)
+		assert.Equal(t, "stale", req.Enrichment[HeaderTimeliness])
+
+		err := chain.Collect(ctx, requestSentAgo(-week, "click"))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+	})
+
+	t.Run("Should flag the batches and leave the limits at zero unchecked", func(t *testing.T) {
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		flag := Policy{MaxAhead: time.Hour, FutureAction: ActionFlag}
+		req := requestSentAgo(-week, "click")
+		assert.NoError(t, flag.Middleware(c).Collect(ctx, req))
+		assert.Equal(t, "click", req.Events[0].Type)
+		assert.Equal(t, map[string]string{HeaderTimeliness: "future"}, req.Enrichment)
+
+		req = requestSentAgo(100*week, "click")
+		assert.NoError(t, flag.Middleware(c).Collect(ctx, req))
+		assert.Nil(t, req.Enrichment)
+	})
+}
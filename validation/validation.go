@@ -0,0 +1,85 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/filter"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// HeaderError is the header telling why a quarantined event is invalid.
+const HeaderError = "raccoon-validation-error"
+
+// Validator is a collector stage checking that the bytes of the events parse as the protobuf message of their type.
+// Events of types without a message are not checked.
+type Validator struct {
+	registry       *schema.Registry
+	requireFields  bool
+	action         filter.Action
+	quarantineType string
+}
+
+// New creates a validator checking the events against the messages of registry. With requireFields, the required
+// fields of proto2 messages must be set. action applies to the invalid events, which are published with
+// quarantineType when quarantined.
+func New(registry *schema.Registry, requireFields bool, action filter.Action, quarantineType string) *Validator {
+	return &Validator{
+		registry:       registry,
+		requireFields:  requireFields,
+		action:         action,
+		quarantineType: quarantineType,
+	}
+}
+
+// validate returns why the event does not parse as the message of its type, nil when it does or has no message.
+func (v *Validator) validate(e *pb.Event) error {
+	md, ok := v.registry.Message(e.Type)
+	if !ok {
+		return nil
+	}
+	return proto.UnmarshalOptions{AllowPartial: !v.requireFields}.Unmarshal(e.EventBytes, dynamicpb.NewMessage(md))
+}
+
+// Middleware applies the action of the validator to the invalid events of every request.
+func (v *Validator) Middleware(next collection.Collector) collection.Collector {
+	return collection.CollectorFunc(func(ctx context.Context, req *collection.CollectRequest) error {
+		group := req.ConnectionIdentifier.Group
+		var events []*pb.Event
+		var reasons []string
+		for i, e := range req.GetEvents() {
+			err := v.validate(e)
+			if err == nil {
+				events = append(events, e)
+				continue
+			}
+			reason := fmt.Sprintf("event %d of type %s: %v", i, e.Type, err)
+			reasons = append(reasons, reason)
+			metrics.Increment("events_invalid_total", fmt.Sprintf("action=%s,conn_group=%s,event_type=%s", v.action, group, e.Type))
+			if v.action == filter.ActionQuarantine {
+				quarantined := req.Retype(e, v.quarantineType)
+				req.SetEventHeader(quarantined, HeaderError, fmt.Sprintf("type %s: %v", e.Type, err))
+				events = append(events, quarantined)
+			}
+		}
+		if len(reasons) == 0 {
+			return next.Collect(ctx, req)
+		}
+		if v.action == filter.ActionReject {
+			return fmt.Errorf("%w: %s", collection.ErrInvalidRequest, strings.Join(reasons, "; "))
+		}
+		logger.Debugf("[validation] %s invalid events from conn_group=%s req_guid=%s: %s", v.action, group, req.GetReqGuid(), strings.Join(reasons, "; "))
+		if len(events) == 0 {
+			return nil
+		}
+		req.Events = events
+		return next.Collect(ctx, req)
+	})
+}
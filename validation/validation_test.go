@@ -0,0 +1,99 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/filter"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	// click has a required id, then an optional timestamp
+	click          = []byte{0x0a, 0x01, 'a', 0x10, 0x01}
+	truncatedClick = []byte{0x0a, 0x05, 'a'}
+	clickWithoutID = []byte{0x10, 0x01}
+)
+
+// registry maps the click type to the test.Click message.
+func registry(t *testing.T) *schema.Registry {
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Click"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("ts"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+			},
+		}},
+	}}}
+	r, err := schema.New(set, map[string]string{"click": "test.Click"})
+	require.NoError(t, err)
+	return r
+}
+
+func requestOf(events ...*pb.Event) *collection.CollectRequest {
+	return &collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "1", Group: "viewer"},
+		SendEventRequest:     &pb.SendEventRequest{ReqGuid: "abc", Events: events},
+	}
+}
+
+func TestValidator_Middleware(t *testing.T) {
+	ctx := context.Background()
+	r := registry(t)
+
+	t.Run("Should reject a request with invalid events", func(t *testing.T) {
+		v := New(r, true, filter.ActionReject, "quarantine")
+		c := &collection.MockCollector{}
+		err := v.Middleware(c).Collect(ctx, requestOf(&pb.Event{EventBytes: click, Type: "click"}, &pb.Event{EventBytes: truncatedClick, Type: "click"}, &pb.Event{EventBytes: clickWithoutID, Type: "click"}))
+		assert.True(t, errors.Is(err, collection.ErrInvalidRequest))
+		assert.Contains(t, err.Error(), "event 1 of type click")
+		assert.Contains(t, err.Error(), "event 2 of type click")
+		assert.NotContains(t, err.Error(), "event 0")
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Should only enforce the required fields when configured", func(t *testing.T) {
+		v := New(r, false, filter.ActionReject, "quarantine")
+		c := &collection.MockCollector{}
+		req := requestOf(&pb.Event{EventBytes: clickWithoutID, Type: "click"}, &pb.Event{EventBytes: []byte("unchecked"), Type: "scroll"})
+		c.On("Collect", ctx, req).Return(nil)
+		assert.NoError(t, v.Middleware(c).Collect(ctx, req))
+		assert.Len(t, req.Events, 2)
+		c.AssertExpectations(t)
+	})
+
+	t.Run("Should quarantine the invalid events", func(t *testing.T) {
+		v := New(r, true, filter.ActionQuarantine, "invalid")
+		c := &collection.MockCollector{}
+		c.On("Collect", ctx, mock.Anything).Return(nil)
+		req := requestOf(&pb.Event{EventBytes: click, Type: "click"}, &pb.Event{EventBytes: truncatedClick, Type: "click"})
+		assert.NoError(t, v.Middleware(c).Collect(ctx, req))
+		require.Len(t, req.Events, 2)
+		assert.Equal(t, "click", req.Events[0].Type)
+		assert.Equal(t, "invalid", req.Events[1].Type)
+		assert.Equal(t, truncatedClick, req.Events[1].EventBytes)
+		assert.Nil(t, req.Headers(req.Events[0]))
+		assert.Contains(t, req.Headers(req.Events[1])[HeaderError], "type click: ")
+		assert.Equal(t, "click", req.Headers(req.Events[1])[collection.HeaderOriginalType])
+	})
+
+	t.Run("Should drop the invalid events", func(t *testing.T) {
+		v := New(r, true, filter.ActionDrop, "quarantine")
+		c := &collection.MockCollector{}
+		assert.NoError(t, v.Middleware(c).Collect(ctx, requestOf(&pb.Event{EventBytes: truncatedClick, Type: "click"})))
+		c.AssertNotCalled(t, "Collect", mock.Anything, mock.Anything)
+	})
+}
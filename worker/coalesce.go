@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/publisher"
+)
+
+// CoalescingConfig bounds the units a worker coalesces requests into before publishing them at once.
+type CoalescingConfig struct {
+	// MaxEvents is the number of events at which a unit is published.
+	MaxEvents int
+	// MaxBytes is the size of the events at which a unit is published.
+	MaxBytes int
+	// Linger is the maximum time a request waits for others to be coalesced with.
+	Linger time.Duration
+}
+
+// EnableCoalescing coalesces the requests of a connection group read by a worker into larger publish units.
+// Must be called before StartWorkers.
+func (w *Pool) EnableCoalescing(cfg CoalescingConfig) {
+	w.coalescing = &cfg
+}
+
+// unit is the requests of a connection group published with a single ProduceBulk call.
+type unit struct {
+	group    string
+	requests []collection.CollectRequest
+	// readTimes is when each request was read by the worker
+	readTimes []time.Time
+	count     int
+	bytes     int
+	deadline  time.Time
+}
+
+// records returns the records of the events of every request, carrying the headers of their request. When keyed,
+// the records are keyed by the id of the connection of their request.
+func (u *unit) records(keyed bool) []publisher.Record {
+	records := make([]publisher.Record, 0, u.count)
+	for _, request := range u.requests {
+		var key []byte
+		if keyed {
+			key = []byte(request.ConnectionIdentifier.ID)
+		}
+		for _, e := range request.GetEvents() {
+			records = append(records, publisher.Record{Event: e, Key: key, Headers: request.Headers(e)})
+		}
+	}
+	return records
+}
+
+// units holds the units of a worker which are not published yet, one per connection group.
+type units struct {
+	config  *CoalescingConfig
+	pending map[string]*unit
+}
+
+func newUnits(config *CoalescingConfig) *units {
+	return &units{config: config, pending: make(map[string]*unit)}
+}
+
+// add coalesces the request into the unit of its group and returns the unit once full. Without coalescing,
+// every request is a full unit on its own.
+func (us *units) add(request collection.CollectRequest, now time.Time) *unit {
+	group := request.ConnectionIdentifier.Group
+	u, ok := us.pending[group]
+	if !ok {
+		u = &unit{group: group}
+		if us.config != nil {
+			u.deadline = now.Add(us.config.Linger)
+		}
+	}
+	u.requests = append(u.requests, request)
+	u.readTimes = append(u.readTimes, now)
+	for _, e := range request.GetEvents() {
+		u.count++
+		u.bytes += len(e.EventBytes)
+	}
+	if us.config == nil || u.count >= us.config.MaxEvents || u.bytes >= us.config.MaxBytes {
+		delete(us.pending, group)
+		return u
+	}
+	us.pending[group] = u
+	return nil
+}
+
+// deadline returns the earliest deadline of the pending units.
+func (us *units) deadline() (time.Time, bool) {
+	var earliest time.Time
+	for _, u := range us.pending {
+		if earliest.IsZero() || u.deadline.Before(earliest) {
+			earliest = u.deadline
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// expired removes and returns the units whose deadline passed.
+func (us *units) expired(now time.Time) []*unit {
+	var expired []*unit
+	for group, u := range us.pending {
+		if !u.deadline.After(now) {
+			expired = append(expired, u)
+			delete(us.pending, group)
+		}
+	}
+	return expired
+}
+
+// flushAll removes and returns every pending unit.
+func (us *units) flushAll() []*unit {
+	var all []*unit
+	for group, u := range us.pending {
+		all = append(all, u)
+		delete(us.pending, group)
+	}
+	return all
+}
+
+// restore puts back units removed from the pending ones but not published.
+func (us *units) restore(rest []*unit) {
+	for _, u := range rest {
+		us.pending[u.group] = u
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func requestOf(group string, events ...string) collection.CollectRequest {
+	request := collection.CollectRequest{
+		ConnectionIdentifier: identification.Identifier{ID: "12345", Group: group},
+		SendEventRequest:     &pb.SendEventRequest{SentTime: timestamppb.Now()},
+	}
+	for _, e := range events {
+		request.Events = append(request.Events, &pb.Event{EventBytes: []byte(e), Type: "click"})
+	}
+	return request
+}
+
+func TestUnits(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Should return every request as a unit without coalescing", func(t *testing.T) {
+		us := newUnits(nil)
+		u := us.add(requestOf("viewer", "a"), now)
+		assert.Len(t, u.requests, 1)
+		_, ok := us.deadline()
+		assert.False(t, ok)
+	})
+
+	t.Run("Should return the unit once max events is reached", func(t *testing.T) {
+		us := newUnits(&CoalescingConfig{MaxEvents: 3, MaxBytes: 100, Linger: time.Second})
+		assert.Nil(t, us.add(requestOf("viewer", "a", "b"), now))
+		assert.Nil(t, us.add(requestOf("driver", "c"), now))
+		u := us.add(requestOf("viewer", "d"), now)
+		assert.Equal(t, "viewer", u.group)
+		assert.Len(t, u.requests, 2)
+		assert.Equal(t, []byte("d"), u.records(false)[2].Event.EventBytes)
+	})
+
+	t.Run("Should return the unit once max bytes is reached", func(t *testing.T) {
+		us := newUnits(&CoalescingConfig{MaxEvents: 100, MaxBytes: 4, Linger: time.Second})
+		assert.Nil(t, us.add(requestOf("viewer", "ab"), now))
+		assert.NotNil(t, us.add(requestOf("viewer", "cd"), now))
+	})
+
+	t.Run("Should expire units after linger", func(t *testing.T) {
+		us := newUnits(&CoalescingConfig{MaxEvents: 100, MaxBytes: 100, Linger: time.Second})
+		us.add(requestOf("viewer", "a"), now)
+		us.add(requestOf("driver", "b"), now.Add(time.Second))
+		deadline, ok := us.deadline()
+		assert.True(t, ok)
+		assert.Equal(t, now.Add(time.Second), deadline)
+		assert.Empty(t, us.expired(now))
+		assert.Len(t, us.expired(now.Add(time.Second)), 1)
+		assert.Len(t, us.flushAll(), 1)
+		assert.Empty(t, us.pending)
+	})
+
+	t.Run("Should give the records of each event the headers of its request", func(t *testing.T) {
+		us := newUnits(&CoalescingConfig{MaxEvents: 3, MaxBytes: 100, Linger: time.Second})
+		us.add(requestOf("viewer", "a"), now)
+		enriched := requestOf("viewer", "b", "c")
+		enriched.Enrichment = map[string]string{"raccoon-ua-os": "iOS"}
+		enriched.SetEventHeader(enriched.Events[1], "raccoon-validation-error", "invalid")
+		enriched.Events[1] = enriched.Retype(enriched.Events[1], "quarantine")
+		u := us.add(enriched, now)
+		var headers []map[string]string
+		for _, r := range u.records(false) {
+			headers = append(headers, r.Headers)
+			assert.Nil(t, r.Key)
+		}
+		assert.Equal(t, []map[string]string{
+			nil,
+			{"raccoon-ua-os": "iOS"},
+			{"raccoon-ua-os": "iOS", "raccoon-validation-error": "invalid", "raccoon-original-type": "click"},
+		}, headers)
+		assert.Equal(t, []byte("12345"), u.records(true)[1].Key)
+	})
+}
+
+func TestPool_Coalescing(t *testing.T) {
+	t.Run("Should publish the requests of a group at once", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.MatchedBy(func(events []*pb.Event) bool { return len(events) == 3 }), "viewer", mock.Anything).Return(nil).Once()
+		kp.On("ProduceBulk", mock.MatchedBy(func(events []*pb.Event) bool { return len(events) == 1 }), "driver", mock.Anything).Return(nil).Once()
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnableCoalescing(CoalescingConfig{MaxEvents: 3, MaxBytes: 100, Linger: 10 * time.Millisecond})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "a")
+		bc <- requestOf("driver", "b")
+		bc <- requestOf("viewer", "c", "d")
+		time.Sleep(30 * time.Millisecond)
+		kp.AssertExpectations(t)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+
+	t.Run("Should publish the pending units when flushing", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.MatchedBy(func(events []*pb.Event) bool { return len(events) == 2 }), "viewer", mock.Anything).Return(nil).Once()
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnableCoalescing(CoalescingConfig{MaxEvents: 100, MaxBytes: 100, Linger: time.Hour})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "a")
+		bc <- requestOf("viewer", "b")
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+)
+
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateStopped = "stopped"
+)
+
+// ErrStopped is returned when resuming a pool stopped by Drain.
+var ErrStopped = errors.New("worker pool is stopped by a drain")
+
+// ErrPauseRefused is returned by Pause when the requests would wait for room in the buffer without bound while paused.
+var ErrPauseRefused = errors.New("worker pool cannot be paused, requests would wait for the buffer without timeout")
+
+// drainPollInterval is how often Drain checks whether the pool is idle.
+var drainPollInterval = 10 * time.Millisecond
+
+// Status describes the pool at a point in time.
+type Status struct {
+	State string `json:"state"`
+	// Workers is the number of workers currently started, not counting the ones of the priority lane
+	Workers int `json:"workers"`
+	// PriorityWorkers is the number of workers of the priority lane currently started
+	PriorityWorkers int `json:"priority_workers"`
+	// Buffered is the number of batches waiting in EventsChannel, in the shards and in the priority lane
+	Buffered int `json:"buffered"`
+	// Busy is the number of batches read by the workers and not yet published
+	Busy int `json:"busy"`
+}
+
+// RefusePause makes Pause fail with ErrPauseRefused, for the collectors which wait for room in the buffer without
+// timeout: they would hold their requests for as long as the pool is paused. Must be called before StartWorkers.
+func (w *Pool) RefusePause() {
+	w.pauseRefused = true
+}
+
+// Pause stops the workers from reading new batches, which are held in EventsChannel until Resume is called. Batches
+// already read are still published. The pool can no longer be paused once flushing. Returns ErrPauseRefused after
+// RefusePause.
+func (w *Pool) Pause() error {
+	if w.pauseRefused {
+		return ErrPauseRefused
+	}
+	w.pause()
+	return nil
+}
+
+func (w *Pool) pause() {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	if w.resumed != nil {
+		return
+	}
+	select {
+	case <-w.flushing:
+		return
+	default:
+	}
+	close(w.paused)
+	w.resumed = make(chan struct{})
+	logger.Info("[worker] pool paused")
+	metrics.Gauge("worker_pool_paused", 1, "")
+}
+
+// Resume lets the workers read the batches again after Pause. Returns ErrStopped once stopped by Drain, the workers
+// then only read the batches again when flushing.
+func (w *Pool) Resume() error {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	if w.stopped {
+		return ErrStopped
+	}
+	w.resume()
+	return nil
+}
+
+// resume lets the workers read the batches again, stopped or not. Must be called with pauseMu held.
+func (w *Pool) resume() {
+	if w.resumed == nil {
+		return
+	}
+	close(w.resumed)
+	w.resumed = nil
+	w.paused = make(chan struct{})
+	logger.Info("[worker] pool resumed")
+	metrics.Gauge("worker_pool_paused", 0, "")
+}
+
+// Paused tells whether the workers are paused, or stopped.
+func (w *Pool) Paused() bool {
+	_, resumed := w.pauseState()
+	return resumed != nil
+}
+
+// pauseState returns the channel closed on Pause and the one closed on Resume, which is nil while running.
+func (w *Pool) pauseState() (paused, resumed <-chan struct{}) {
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	return w.paused, w.resumed
+}
+
+// Drain lets the workers run until every buffered batch is published, then stops them. New batches are not held back
+// while draining, Drain returns once the pool was seen idle. Returns true if the pool could not be drained within the
+// timeout, the pool is stopped either way. A stopped pool cannot be resumed, the batches buffered since are held
+// until the next Drain or until flushing.
+func (w *Pool) Drain(timeout time.Duration) bool {
+	logger.Info(fmt.Sprintf("[worker] draining pool, %d batches buffered", w.buffered()))
+	w.pauseMu.Lock()
+	w.stopped = false
+	w.resume()
+	w.pauseMu.Unlock()
+	defer w.stop()
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	// a batch being handed from the channel to a worker is briefly neither buffered nor busy
+	idleChecks := 0
+	for idleChecks < 2 {
+		select {
+		case <-deadline:
+			logger.Info(fmt.Sprintf("[worker] drain timed out, %d batches buffered, %d busy", w.buffered(), atomic.LoadInt32(&w.busy)))
+			return true
+		case <-ticker.C:
+			if w.buffered() == 0 && atomic.LoadInt32(&w.busy) == 0 {
+				idleChecks++
+			} else {
+				idleChecks = 0
+			}
+		}
+	}
+	return false
+}
+
+// stop pauses the workers for good, unless flushing.
+func (w *Pool) stop() {
+	w.pause()
+	w.pauseMu.Lock()
+	defer w.pauseMu.Unlock()
+	if w.resumed != nil {
+		w.stopped = true
+		logger.Info("[worker] pool stopped")
+	}
+}
+
+// Status returns the current state of the pool.
+func (w *Pool) Status() Status {
+	w.pauseMu.Lock()
+	state := StateRunning
+	switch {
+	case w.stopped:
+		state = StateStopped
+	case w.resumed != nil:
+		state = StatePaused
+	}
+	w.pauseMu.Unlock()
+	return Status{
+		State:           state,
+		Workers:         int(atomic.LoadInt32(&w.running)),
+		PriorityWorkers: int(atomic.LoadInt32(&w.priorityRunning)),
+		Buffered:        w.buffered(),
+		Busy:            int(atomic.LoadInt32(&w.busy)),
+	}
+}
+
+func (w *Pool) buffered() int {
+	n := len(w.EventsChannel) + len(w.priority)
+	for _, shard := range w.shards {
+		n += len(shard)
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPool_Pause(t *testing.T) {
+	t.Run("Should hold the batches while paused and publish them once resumed", func(t *testing.T) {
+		var published int32
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+			atomic.AddInt32(&published, 1)
+		})
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(2, bc, 10, 0, mockProducerPool{&kp})
+		pool.Pause()
+		pool.StartWorkers()
+
+		for i := 0; i < 3; i++ {
+			bc <- requestOf("viewer", "a")
+		}
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&published))
+		assert.Equal(t, Status{State: StatePaused, Workers: 2, Buffered: 3}, pool.Status())
+
+		assert.NoError(t, pool.Resume())
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&published) == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, StateRunning, pool.Status().State)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+
+	t.Run("Should publish the held batches when flushing while paused", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(2, bc, 10, 0, mockProducerPool{&kp})
+		pool.StartWorkers()
+		pool.Pause()
+		pool.Pause()
+
+		bc <- requestOf("viewer", "a")
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 1)
+		assert.False(t, pool.Paused())
+	})
+
+	t.Run("Should refuse to pause after RefusePause", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(2, bc, 10, 0, mockProducerPool{&kp})
+		pool.RefusePause()
+		pool.StartWorkers()
+
+		assert.Equal(t, ErrPauseRefused, pool.Pause())
+		assert.False(t, pool.Paused())
+		assert.False(t, pool.Drain(time.Second))
+		assert.Equal(t, StateStopped, pool.Status().State)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+}
+
+func TestPool_Drain(t *testing.T) {
+	t.Run("Should publish the buffered batches and leave the pool stopped", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(2, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnableSharding(2)
+		pool.Pause()
+		pool.StartWorkers()
+		for i := 0; i < 5; i++ {
+			bc <- requestOf("viewer", "a")
+		}
+
+		assert.False(t, pool.Drain(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 5)
+		assert.Equal(t, Status{State: StateStopped, Workers: 2}, pool.Status())
+
+		bc <- requestOf("viewer", "a")
+		pool.Pause()
+		assert.Equal(t, ErrStopped, pool.Resume())
+		time.Sleep(20 * time.Millisecond)
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 5)
+		assert.Equal(t, StateStopped, pool.Status().State)
+
+		assert.False(t, pool.Drain(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 6)
+		bc <- requestOf("viewer", "a")
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 7)
+	})
+
+	t.Run("Should time out while a batch is being published", func(t *testing.T) {
+		release := make(chan struct{})
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+			<-release
+		})
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.StartWorkers()
+		bc <- requestOf("viewer", "a")
+
+		assert.True(t, pool.Drain(50*time.Millisecond))
+		assert.Equal(t, StateStopped, pool.Status().State)
+		close(release)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+}
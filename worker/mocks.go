@@ -1,7 +1,10 @@
 package worker
 
 import (
+	"context"
+
 	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/publisher"
 	mock "github.com/stretchr/testify/mock"
 	kafka "gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
 )
@@ -11,12 +14,25 @@ type mockKafkaPublisher struct {
 	mock.Mock
 }
 
-// ProduceBulk provides a mock function with given fields: events, deliveryChannel
-func (m *mockKafkaPublisher) ProduceBulk(events []*pb.Event, connGroup string, deliveryChannel chan kafka.Event) error {
+// ProduceBulk provides a mock function with given fields: events, connGroup, deliveryChannel
+func (m *mockKafkaPublisher) ProduceBulk(ctx context.Context, records []publisher.Record, connGroup string, deliveryChannel chan kafka.Event) error {
+	events := make([]*pb.Event, len(records))
+	for i, r := range records {
+		events[i] = r.Event
+	}
 	mock := m.Called(events, connGroup, deliveryChannel)
 	return mock.Error(0)
 }
 
+// mockProducerPool assigns the same publisher to every worker
+type mockProducerPool struct {
+	publisher publisher.KafkaProducer
+}
+
+func (m mockProducerPool) Get(string) publisher.KafkaProducer {
+	return m.publisher
+}
+
 type mockMetric struct {
 	mock.Mock
 }
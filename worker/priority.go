@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// EnablePriorityLane starts size workers dedicated to the requests of events, besides the ones reading EventsChannel.
+// Requests of the lane never wait behind those of EventsChannel. The lane is not sharded nor autoscaled, but it is
+// paused, drained and flushed along with the pool. Must be called before StartWorkers.
+func (w *Pool) EnablePriorityLane(events <-chan collection.CollectRequest, size int) {
+	w.priority = events
+	w.prioritySize = size
+}
+
+func (w *Pool) startPriorityLane() {
+	for i := 0; i < w.prioritySize; i++ {
+		atomic.AddInt32(&w.priorityRunning, 1)
+		w.wg.Add(1)
+		go func(workerName string) {
+			defer atomic.AddInt32(&w.priorityRunning, -1)
+			w.work(workerName, w.priority, w.flushing, nil)
+		}(fmt.Sprintf("priority-worker-%d", i))
+	}
+	if w.prioritySize > 0 {
+		metrics.Gauge("worker_priority_pool_size", w.prioritySize, "")
+	}
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPool_PriorityLane(t *testing.T) {
+	t.Run("Should publish the priority requests while the bulk workers are busy", func(t *testing.T) {
+		release := make(chan struct{})
+		published := make(chan string, 10)
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			events := args.Get(0).([]*pb.Event)
+			if string(events[0].EventBytes) == "bulk" {
+				<-release
+			}
+			published <- string(events[0].EventBytes)
+		})
+		bc := make(chan collection.CollectRequest, 10)
+		priority := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnablePriorityLane(priority, 1)
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "bulk")
+		bc <- requestOf("viewer", "bulk")
+		priority <- requestOf("viewer", "payment")
+		select {
+		case event := <-published:
+			assert.Equal(t, "payment", event)
+		case <-time.After(time.Second):
+			assert.Fail(t, "priority request not published")
+		}
+		assert.Equal(t, 1, pool.Status().Workers)
+		assert.Equal(t, 1, pool.Status().PriorityWorkers)
+
+		close(release)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 3)
+	})
+
+	t.Run("Should hold the priority requests while paused", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		bc := make(chan collection.CollectRequest, 10)
+		priority := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnablePriorityLane(priority, 2)
+		pool.Pause()
+		pool.StartWorkers()
+
+		priority <- requestOf("viewer", "payment")
+		time.Sleep(20 * time.Millisecond)
+		kp.AssertNotCalled(t, "ProduceBulk", mock.Anything, mock.Anything, mock.Anything)
+		assert.Equal(t, Status{State: StatePaused, Workers: 1, PriorityWorkers: 2, Buffered: 1}, pool.Status())
+
+		assert.False(t, pool.Drain(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 1)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+}
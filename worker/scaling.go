@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// ScalingConfig bounds the pool size and tells when to change it.
+type ScalingConfig struct {
+	// MinSize and MaxSize bound the number of workers.
+	MinSize int
+	MaxSize int
+	// Interval is the time between two scaling decisions, the pool changes by one worker at most per decision.
+	Interval time.Duration
+	// ScaleUpOccupancy is the buffer channel occupancy, in percent, at or above which a worker is added.
+	ScaleUpOccupancy int
+	// ScaleDownOccupancy is the buffer channel occupancy, in percent, at or below which a worker is removed.
+	ScaleDownOccupancy int
+	// ScaleUpLatency is the mean publish latency of a batch at or above which a worker is added. Zero ignores the latency.
+	ScaleUpLatency time.Duration
+}
+
+// decide returns the change to apply to the pool size given the buffer occupancy in percent and the mean publish latency.
+func (c ScalingConfig) decide(size, occupancy int, latency time.Duration) int {
+	slow := c.ScaleUpLatency > 0 && latency >= c.ScaleUpLatency
+	switch {
+	case (occupancy >= c.ScaleUpOccupancy || slow) && size < c.MaxSize:
+		return 1
+	case occupancy <= c.ScaleDownOccupancy && !slow && size > c.MinSize:
+		return -1
+	}
+	return 0
+}
+
+func (w *Pool) recordPublish(d time.Duration) {
+	atomic.AddInt64(&w.publishNanos, int64(d))
+	atomic.AddInt64(&w.publishCount, 1)
+}
+
+// occupancy returns the percentage of the buffer channel filled with batches waiting for a worker.
+func (w *Pool) occupancy() int {
+	if cap(w.EventsChannel) == 0 {
+		return 0
+	}
+	return len(w.EventsChannel) * 100 / cap(w.EventsChannel)
+}
+
+// meanPublishLatency returns the mean latency of the batches published since the last call.
+func (w *Pool) meanPublishLatency() time.Duration {
+	nanos := atomic.SwapInt64(&w.publishNanos, 0)
+	count := atomic.SwapInt64(&w.publishCount, 0)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(nanos / count)
+}
+
+func (w *Pool) autoscale() {
+	defer close(w.scalerDone)
+	ticker := time.NewTicker(w.scaling.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopScaling:
+			return
+		case <-ticker.C:
+			w.scale()
+		}
+	}
+}
+
+// scale takes one scaling decision and applies it.
+func (w *Pool) scale() {
+	if w.Paused() {
+		// the buffer fills up on purpose while paused
+		return
+	}
+	size := int(atomic.LoadInt32(&w.running))
+	occupancy := w.occupancy()
+	latency := w.meanPublishLatency()
+	switch w.scaling.decide(size, occupancy, latency) {
+	case 1:
+		w.spawn(w.EventsChannel, w.flushing)
+		size++
+		metrics.Increment("worker_pool_scaling_total", "direction=up")
+	case -1:
+		select {
+		case w.retire <- struct{}{}:
+			size--
+			metrics.Increment("worker_pool_scaling_total", "direction=down")
+		default:
+			// every worker is busy, the pool is not oversized after all
+			return
+		}
+	default:
+		return
+	}
+	logger.Info(fmt.Sprintf("[worker] scaled pool to %d workers, buffer occupancy %d%%, publish latency %v", size, occupancy, latency))
+	metrics.Gauge("worker_pool_size", size, "")
+}
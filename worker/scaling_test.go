@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestScalingConfig_Decide(t *testing.T) {
+	cfg := ScalingConfig{MinSize: 1, MaxSize: 4, ScaleUpOccupancy: 75, ScaleDownOccupancy: 10, ScaleUpLatency: 100 * time.Millisecond}
+
+	t.Run("Should add a worker when the buffer fills up", func(t *testing.T) {
+		assert.Equal(t, 1, cfg.decide(2, 80, 0))
+	})
+	t.Run("Should add a worker when publishing is slow", func(t *testing.T) {
+		assert.Equal(t, 1, cfg.decide(2, 50, 150*time.Millisecond))
+	})
+	t.Run("Should not grow past the max size", func(t *testing.T) {
+		assert.Equal(t, 0, cfg.decide(4, 100, time.Second))
+	})
+	t.Run("Should remove a worker when the buffer is nearly empty", func(t *testing.T) {
+		assert.Equal(t, -1, cfg.decide(2, 5, 10*time.Millisecond))
+	})
+	t.Run("Should not remove a worker while publishing is slow", func(t *testing.T) {
+		assert.Equal(t, 0, cfg.decide(4, 5, 150*time.Millisecond))
+	})
+	t.Run("Should not shrink below the min size", func(t *testing.T) {
+		assert.Equal(t, 0, cfg.decide(1, 0, 0))
+	})
+	t.Run("Should ignore latency when no threshold is set", func(t *testing.T) {
+		assert.Equal(t, 0, ScalingConfig{MinSize: 1, MaxSize: 4, ScaleUpOccupancy: 75, ScaleDownOccupancy: 10}.decide(2, 50, time.Hour))
+	})
+}
+
+func TestPool_Scale(t *testing.T) {
+	t.Run("Should grow the pool while the buffer is full and shrink it once drained", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		unblock := make(chan time.Time)
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).WaitUntil(unblock)
+		bc := make(chan collection.CollectRequest, 4)
+		pool := CreateWorkerPool(1, bc, 0, 0, mockProducerPool{&kp})
+		pool.EnableAutoscaling(ScalingConfig{MinSize: 1, MaxSize: 3, Interval: time.Hour, ScaleUpOccupancy: 75, ScaleDownOccupancy: 10})
+		pool.StartWorkers()
+
+		for i := 0; i < 5; i++ {
+			bc <- collection.CollectRequest{}
+		}
+		pool.scale()
+		assert.Equal(t, int32(2), atomic.LoadInt32(&pool.running))
+
+		close(unblock)
+		assert.Eventually(t, func() bool {
+			pool.scale()
+			return atomic.LoadInt32(&pool.running) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		close(bc)
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+	})
+}
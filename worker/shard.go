@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+)
+
+// shardOf returns the shard of the connection among count shards.
+func shardOf(identifier identification.Identifier, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(identifier.Group))
+	h.Write([]byte{0})
+	h.Write([]byte(identifier.ID))
+	return int(h.Sum32() % uint32(count))
+}
+
+func (w *Pool) startShards() {
+	for _, shard := range w.shards {
+		w.spawn(shard, nil)
+	}
+	metrics.Gauge("worker_pool_size", w.Size, "")
+	w.dispatched = make(chan struct{})
+	w.wg.Add(1)
+	go w.dispatch()
+}
+
+// dispatch routes the requests to the shard of their connection. The shards are closed once flushed, stopping the workers
+// after they processed their shard. Dispatching waits while the shard of a request is full, holding back the requests of
+// every other connection meanwhile, as routing around the shard would reorder its connection. Once flushing timed out,
+// the request waiting for its shard is left to Unprocessed.
+func (w *Pool) dispatch() {
+	defer w.wg.Done()
+	defer func() {
+		for _, shard := range w.shards {
+			close(shard)
+		}
+		close(w.dispatched)
+	}()
+	for {
+		request, _, ok := w.next(w.EventsChannel, w.flushing, nil, nil)
+		if !ok {
+			return
+		}
+		atomic.AddInt32(&w.busy, 1)
+		i := shardOf(request.ConnectionIdentifier, len(w.shards))
+		tags := fmt.Sprintf("worker=worker-%d", i)
+		metrics.Increment("worker_shard_batches_total", tags)
+		metrics.Gauge("worker_shard_batches_current", len(w.shards[i]), tags)
+		select {
+		case w.shards[i] <- request:
+		case <-w.ctx.Done():
+			w.abandonedMu.Lock()
+			w.abandoned = append(w.abandoned, request)
+			w.abandonedMu.Unlock()
+			atomic.AddInt32(&w.busy, -1)
+			return
+		}
+		atomic.AddInt32(&w.busy, -1)
+	}
+}
+
+// Unprocessed removes and returns the requests left in the shards and the ones the workers gave up publishing once
+// flushing timed out. Requests left in EventsChannel are not included. Must be called after FlushWithTimeOut, the shards
+// are drained once the requests are no longer routed to them.
+func (w *Pool) Unprocessed() []collection.CollectRequest {
+	if w.dispatched != nil {
+		select {
+		case <-w.dispatched:
+		case <-time.After(stopTimeout):
+			logger.Errorf("[worker] requests still routed to the shards %v after flushing", stopTimeout)
+		}
+	}
+	w.abandonedMu.Lock()
+	requests := w.abandoned
+	w.abandoned = nil
+	w.abandonedMu.Unlock()
+	for _, shard := range w.shards {
+		requests = append(requests, collection.DrainChannel(shard)...)
+	}
+	return requests
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/identification"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestShardOf(t *testing.T) {
+	connection := identification.Identifier{ID: "12345", Group: "viewer"}
+	assert.Equal(t, shardOf(connection, 8), shardOf(connection, 8))
+
+	shards := make(map[int]struct{})
+	for i := 0; i < 100; i++ {
+		shards[shardOf(identification.Identifier{ID: fmt.Sprint(i), Group: "viewer"}, 8)] = struct{}{}
+	}
+	assert.Len(t, shards, 8)
+}
+
+func TestPool_Sharded(t *testing.T) {
+	t.Run("Should publish the requests of a connection in order", func(t *testing.T) {
+		var m sync.Mutex
+		published := make(map[string][]string)
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			events := args.Get(0).([]*pb.Event)
+			time.Sleep(time.Duration(len(events[0].EventBytes)%3) * time.Millisecond)
+			m.Lock()
+			published[events[0].Type] = append(published[events[0].Type], string(events[0].EventBytes))
+			m.Unlock()
+		})
+		bc := make(chan collection.CollectRequest, 100)
+		pool := CreateWorkerPool(4, bc, 10, 0, mockProducerPool{&kp})
+		pool.EnableSharding(2)
+		pool.StartWorkers()
+
+		var want []string
+		for i := 0; i < 20; i++ {
+			want = append(want, fmt.Sprint(i))
+			for _, conn := range []string{"a", "b", "c"} {
+				bc <- collection.CollectRequest{
+					ConnectionIdentifier: identification.Identifier{ID: conn, Group: "viewer"},
+					SendEventRequest: &pb.SendEventRequest{
+						SentTime: timestamppb.Now(),
+						Events:   []*pb.Event{{EventBytes: []byte(fmt.Sprint(i)), Type: conn}},
+					},
+				}
+			}
+		}
+
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		for _, conn := range []string{"a", "b", "c"} {
+			assert.Equal(t, want, published[conn])
+		}
+		assert.Empty(t, pool.Unprocessed())
+	})
+	t.Run("Should leave the request waiting for its shard to Unprocessed once timed out", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+			<-pool.ctx.Done()
+		})
+		pool.EnableSharding(1)
+		pool.StartWorkers()
+		for i := 0; i < 3; i++ {
+			bc <- requestOf("viewer", "a")
+		}
+		// the worker publishes the first request, the second fills the shard and the third waits for it
+		assert.Eventually(t, func() bool { return len(bc) == 0 }, time.Second, time.Millisecond)
+
+		assert.True(t, pool.FlushWithTimeOut(20*time.Millisecond))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 1)
+		assert.Len(t, pool.Unprocessed(), 2)
+		assert.Equal(t, 0, pool.Status().Busy)
+	})
+}
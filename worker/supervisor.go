@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+
+	"github.com/odpf/raccoon/collection"
+	"github.com/odpf/raccoon/logger"
+	"github.com/odpf/raccoon/metrics"
+	"github.com/odpf/raccoon/recovery"
+)
+
+// handling is what a worker is working on, for its supervisor to clean up when it panics.
+type handling struct {
+	// requests are the requests being handled, which are dropped or quarantined on panic
+	requests []collection.CollectRequest
+	// rest are the units removed from the pending ones to be published after requests
+	rest []*unit
+}
+
+// EnableQuarantine appends the batches a worker panicked on to the file at path, in the format of the recovery file.
+// Without quarantine they are dropped. Must be called before StartWorkers.
+func (w *Pool) EnableQuarantine(path string) {
+	w.quarantineFile = path
+}
+
+// supervise runs the worker, recovering it from a panic. Returns true when the worker panicked and should be restarted.
+func (w *Pool) supervise(workerName string, h *handling, run func()) (panicked bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicked = true
+		logger.Errorf("[worker] %s panicked handling %s: %v\n%s", workerName, describe(h.requests), r, debug.Stack())
+		metrics.Increment("worker_panics_total", "worker="+workerName)
+		atomic.AddInt32(&w.busy, -int32(len(h.requests)))
+		w.quarantine(h.requests)
+		h.requests = nil
+	}()
+	run()
+	return false
+}
+
+// quarantine keeps the requests a worker panicked on out of the pool.
+func (w *Pool) quarantine(requests []collection.CollectRequest) {
+	if len(requests) == 0 {
+		return
+	}
+	bucket, status := "kafka_messages_delivered_total", "success=false,"
+	if w.quarantineFile != "" {
+		w.quarantineMu.Lock()
+		events, err := recovery.Append(w.quarantineFile, requests)
+		w.quarantineMu.Unlock()
+		if err != nil {
+			logger.Errorf("[worker] failed to quarantine %s to %s: %v", describe(requests), w.quarantineFile, err)
+		} else {
+			logger.Info(fmt.Sprintf("[worker] quarantined %d events to %s", events, w.quarantineFile))
+			bucket, status = "events_quarantined_total", ""
+		}
+	}
+	for _, req := range requests {
+		for _, e := range req.GetEvents() {
+			metrics.Increment(bucket, fmt.Sprintf("%sconn_group=%s,event_type=%s", status, req.ConnectionIdentifier.Group, e.Type))
+		}
+	}
+}
+
+// describe returns the metadata of the requests to be logged.
+func describe(requests []collection.CollectRequest) string {
+	descriptions := make([]string, len(requests))
+	for i, req := range requests {
+		descriptions[i] = fmt.Sprintf("{conn_group=%s conn_id=%s req_guid=%s events=%d}", req.ConnectionIdentifier.Group, req.ConnectionIdentifier.ID, req.GetReqGuid(), len(req.GetEvents()))
+	}
+	return "[" + strings.Join(descriptions, " ") + "]"
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/odpf/raccoon/collection"
+	pb "github.com/odpf/raccoon/proto"
+	"github.com/odpf/raccoon/recovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// panickingPublisher panics when producing the event poison.
+func panickingPublisher() *mockKafkaPublisher {
+	kp := &mockKafkaPublisher{}
+	kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		for _, e := range args.Get(0).([]*pb.Event) {
+			if string(e.EventBytes) == "poison" {
+				panic("poisoned")
+			}
+		}
+	})
+	return kp
+}
+
+func TestPool_Supervised(t *testing.T) {
+	t.Run("Should restart a worker after a panic", func(t *testing.T) {
+		kp := panickingPublisher()
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{kp})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "a")
+		bc <- requestOf("viewer", "poison")
+		bc <- requestOf("viewer", "b")
+
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 3)
+		assert.Equal(t, Status{State: StateRunning}, pool.Status())
+	})
+
+	t.Run("Should quarantine the batch a worker panicked on", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "quarantine")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		path := filepath.Join(dir, "quarantine.jsonl")
+
+		kp := panickingPublisher()
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{kp})
+		pool.EnableQuarantine(path)
+		pool.EnableCoalescing(CoalescingConfig{MaxEvents: 2, MaxBytes: 1000, Linger: time.Hour})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "a")
+		bc <- requestOf("viewer", "poison")
+		bc <- requestOf("driver", "b")
+
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		quarantined, err := recovery.Load(path)
+		require.NoError(t, err)
+		require.Len(t, quarantined, 2)
+		assert.Equal(t, []byte("a"), quarantined[0].Events[0].EventBytes)
+		assert.Equal(t, []byte("poison"), quarantined[1].Events[0].EventBytes)
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 2)
+	})
+
+	t.Run("Should publish the units left when a worker panics while flushing", func(t *testing.T) {
+		kp := panickingPublisher()
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{kp})
+		pool.EnableCoalescing(CoalescingConfig{MaxEvents: 10, MaxBytes: 1000, Linger: time.Hour})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "poison")
+		bc <- requestOf("driver", "a")
+		bc <- requestOf("rider", "b")
+
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 3)
+	})
+
+	t.Run("Should not panic when the producer fails as a whole", func(t *testing.T) {
+		kp := mockKafkaPublisher{}
+		kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("producer closed"))
+		bc := make(chan collection.CollectRequest, 10)
+		pool := CreateWorkerPool(1, bc, 10, 0, mockProducerPool{&kp})
+		pool.StartWorkers()
+
+		bc <- requestOf("viewer", "a", "b")
+		assert.False(t, pool.FlushWithTimeOut(time.Second))
+		kp.AssertNumberOfCalls(t, "ProduceBulk", 1)
+	})
+}
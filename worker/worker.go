@@ -1,8 +1,11 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/odpf/raccoon/collection"
@@ -12,68 +15,329 @@ import (
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
 )
 
+// ProducerPool assigns each worker the publisher it produces with.
+type ProducerPool interface {
+	Get(key string) publisher.KafkaProducer
+}
+
 // Pool spawn goroutine as much as Size that will listen to EventsChannel. On Close, wait for all data in EventsChannel to be processed.
 type Pool struct {
 	Size                int
 	deliveryChannelSize int
-	EventsChannel       <-chan collection.CollectRequest
-	kafkaProducer       publisher.KafkaProducer
-	wg                  sync.WaitGroup
+	// deliveryTimeout bounds the wait for the delivery reports of a batch, zero waits without deadline
+	deliveryTimeout time.Duration
+	EventsChannel   <-chan collection.CollectRequest
+	producers       ProducerPool
+	wg              sync.WaitGroup
+	// ctx is cancelled when flushing times out so that workers stop waiting on delivery reports
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// running is the number of workers currently started
+	running int32
+	nextID  int
+	scaling *ScalingConfig
+	// retire is received by an idle worker which should stop when scaling down
+	retire chan struct{}
+	// flushing is closed when flushing, workers stop once EventsChannel is empty
+	flushing chan struct{}
+	// shards holds the channel of each worker when requests are routed by connection, nil otherwise
+	shards []chan collection.CollectRequest
+	// dispatched is closed once the requests are no longer routed to the shards
+	dispatched chan struct{}
+	// priority is the channel of the priority lane, served by prioritySize dedicated workers
+	priority     <-chan collection.CollectRequest
+	prioritySize int
+	// priorityRunning is the number of workers of the priority lane currently started
+	priorityRunning int32
+	// coalescing bounds the units requests are coalesced into, nil publishes each request on its own
+	coalescing  *CoalescingConfig
+	stopScaling chan struct{}
+	scalerDone  chan struct{}
+	// publishNanos and publishCount accumulate the ProduceBulk latency since the last scaling decision
+	publishNanos int64
+	publishCount int64
+	// quarantineFile is where the batches a worker panicked on are appended, empty drops them
+	quarantineFile string
+	quarantineMu   sync.Mutex
+	// paused is closed when the workers should stop reading batches, resumed when they should read them again. Only one
+	// of them is open at a time, resumed is nil while running.
+	paused  chan struct{}
+	resumed chan struct{}
+	// stopped is set by Drain, Resume no longer lets the workers read batches
+	stopped bool
+	// pauseRefused makes Pause fail, the collectors would wait for room in the buffer without bound
+	pauseRefused bool
+	pauseMu      sync.Mutex
+	// busy is the number of batches read from the channels and not yet published
+	busy int32
+	// abandoned are the requests the workers gave up publishing once flushing timed out
+	abandoned   []collection.CollectRequest
+	abandonedMu sync.Mutex
 }
 
+// stopTimeout bounds the wait for the workers to stop once flushing timed out.
+var stopTimeout = 5 * time.Second
+
 // CreateWorkerPool create new Pool struct given size and EventsChannel worker.
-func CreateWorkerPool(size int, eventsChannel <-chan collection.CollectRequest, deliveryChannelSize int, kafkaProducer publisher.KafkaProducer) *Pool {
+func CreateWorkerPool(size int, eventsChannel <-chan collection.CollectRequest, deliveryChannelSize int, deliveryTimeout time.Duration, producers ProducerPool) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Pool{
 		Size:                size,
 		deliveryChannelSize: deliveryChannelSize,
+		deliveryTimeout:     deliveryTimeout,
 		EventsChannel:       eventsChannel,
-		kafkaProducer:       kafkaProducer,
+		producers:           producers,
 		wg:                  sync.WaitGroup{},
+		ctx:                 ctx,
+		cancel:              cancel,
+		retire:              make(chan struct{}),
+		flushing:            make(chan struct{}),
+		stopScaling:         make(chan struct{}),
+		paused:              make(chan struct{}),
+	}
+}
+
+// EnableAutoscaling lets the pool grow and shrink between the configured bounds once started. Must be called before StartWorkers.
+func (w *Pool) EnableAutoscaling(cfg ScalingConfig) {
+	w.scaling = &cfg
+}
+
+// EnableSharding routes the requests of a connection to the same worker, so that they are published in the order they
+// were received. Each worker gets a channel of shardSize requests. Must be called before StartWorkers, the pool is not
+// autoscaled when sharded.
+func (w *Pool) EnableSharding(shardSize int) {
+	w.shards = make([]chan collection.CollectRequest, w.Size)
+	for i := range w.shards {
+		w.shards[i] = make(chan collection.CollectRequest, shardSize)
 	}
 }
 
 // StartWorkers initialize worker pool as much as Pool.Size
 func (w *Pool) StartWorkers() {
-	w.wg.Add(w.Size)
+	w.startPriorityLane()
+	if w.shards != nil {
+		w.startShards()
+		return
+	}
 	for i := 0; i < w.Size; i++ {
-		go func(workerName string) {
-			logger.Info("Running worker: " + workerName)
-			deliveryChan := make(chan kafka.Event, w.deliveryChannelSize)
-			for request := range w.EventsChannel {
-				metrics.Timing("batch_idle_in_channel_milliseconds", (time.Now().Sub(request.TimePushed)).Milliseconds(), "worker="+workerName)
-				batchReadTime := time.Now()
-				//@TODO - Should add integration tests to prove that the worker receives the same message that it produced, on the delivery channel it created
-
-				err := w.kafkaProducer.ProduceBulk(request.GetEvents(), request.ConnectionIdentifier.Group, deliveryChan)
-				totalErr := 0
-
-				if err != nil {
-					for _, err := range err.(publisher.BulkError).Errors {
-						if err != nil {
-							logger.Errorf("[worker] Fail to publish message to kafka %v", err)
-							totalErr++
-						}
-					}
-				}
-				lenBatch := int64(len(request.GetEvents()))
-				logger.Debug(fmt.Sprintf("Success sending messages, %v", lenBatch-int64(totalErr)))
-				if lenBatch > 0 {
-					eventTimingMs := time.Since(request.GetSentTime().AsTime()).Milliseconds() / lenBatch
-					metrics.Timing("event_processing_duration_milliseconds", eventTimingMs, fmt.Sprintf("conn_group=%s", request.ConnectionIdentifier.Group))
-					now := time.Now()
-					metrics.Timing("worker_processing_duration_milliseconds", (now.Sub(batchReadTime).Milliseconds())/lenBatch, "worker="+workerName)
-					metrics.Timing("server_processing_latency_milliseconds", (now.Sub(request.TimeConsumed)).Milliseconds()/lenBatch, fmt.Sprintf("conn_group=%s", request.ConnectionIdentifier.Group))
-				}
+		w.spawn(w.EventsChannel, w.flushing)
+	}
+	metrics.Gauge("worker_pool_size", w.Size, "")
+	if w.scaling != nil && w.scaling.MaxSize > w.scaling.MinSize {
+		w.scalerDone = make(chan struct{})
+		go w.autoscale()
+	}
+}
+
+// spawn starts a worker processing the requests of events. The worker stops once events is empty when flushing is closed.
+func (w *Pool) spawn(events <-chan collection.CollectRequest, flushing <-chan struct{}) {
+	workerName := fmt.Sprintf("worker-%d", w.nextID)
+	w.nextID++
+	atomic.AddInt32(&w.running, 1)
+	w.wg.Add(1)
+	go func() {
+		defer atomic.AddInt32(&w.running, -1)
+		w.work(workerName, events, flushing, w.retire)
+	}()
+}
+
+// work processes the requests of events until flushed, or until retire is received. The worker is restarted when it
+// panics, keeping its pending units.
+func (w *Pool) work(workerName string, events <-chan collection.CollectRequest, flushing, retire <-chan struct{}) {
+	defer w.wg.Done()
+	units := newUnits(w.coalescing)
+	h := &handling{}
+	for w.supervise(workerName, h, func() { w.process(workerName, events, flushing, retire, units, h) }) {
+		units.restore(h.rest)
+		logger.Info("Restarting worker: " + workerName)
+	}
+}
+
+// process publishes the requests of events as they are coalesced into units. The requests being handled are kept in h.
+func (w *Pool) process(workerName string, events <-chan collection.CollectRequest, flushing, retire <-chan struct{}, units *units, h *handling) {
+	logger.Info("Running worker: " + workerName)
+	p := &workerPublisher{name: workerName, producer: w.producers.Get(workerName), deliveryChan: make(chan kafka.Event, w.deliveryChannelSize), pool: w}
+	publish := func(us ...*unit) {
+		for i, u := range us {
+			if w.ctx.Err() != nil {
+				// flushing timed out, the producers are about to be closed
+				w.abandon(us[i:])
+				break
+			}
+			h.requests, h.rest = u.requests, us[i+1:]
+			p.publish(u)
+			atomic.AddInt32(&w.busy, -int32(len(u.requests)))
+		}
+		h.requests, h.rest = nil, nil
+	}
+	for {
+		var linger <-chan time.Time
+		var timer *time.Timer
+		if deadline, ok := units.deadline(); ok {
+			timer = time.NewTimer(time.Until(deadline))
+			linger = timer.C
+		}
+		request, lingered, ok := w.next(events, flushing, retire, linger)
+		if timer != nil {
+			timer.Stop()
+		}
+		if !ok {
+			publish(units.flushAll()...)
+			logger.Info("Stopping worker: " + workerName)
+			return
+		}
+		if lingered {
+			publish(units.expired(time.Now())...)
+			continue
+		}
+		atomic.AddInt32(&w.busy, 1)
+		h.requests = []collection.CollectRequest{request}
+		metrics.Timing("batch_idle_in_channel_milliseconds", (time.Now().Sub(request.TimePushed)).Milliseconds(), "worker="+workerName)
+		unit := units.add(request, time.Now())
+		h.requests = nil
+		if unit != nil {
+			publish(unit)
+		}
+	}
+}
+
+// abandon keeps the requests of the units for Unprocessed.
+func (w *Pool) abandon(us []*unit) {
+	w.abandonedMu.Lock()
+	defer w.abandonedMu.Unlock()
+	for _, u := range us {
+		w.abandoned = append(w.abandoned, u.requests...)
+		atomic.AddInt32(&w.busy, -int32(len(u.requests)))
+	}
+}
+
+// workerPublisher publishes the units of a worker.
+type workerPublisher struct {
+	name         string
+	producer     publisher.KafkaProducer
+	deliveryChan chan kafka.Event
+	pool         *Pool
+}
+
+// publish produces the events of every request of the unit at once, then reports the result of each request.
+func (p *workerPublisher) publish(u *unit) {
+	//@TODO - Should add integration tests to prove that the worker receives the same message that it produced, on the delivery channel it created
+	publishTime := time.Now()
+	ctx, cancel := p.pool.batchContext()
+	// sharded workers keep the order of a connection, which Kafka keeps within a partition only
+	records := u.records(p.pool.shards != nil)
+	err := p.producer.ProduceBulk(ctx, records, u.group, p.deliveryChan)
+	cancel()
+	p.pool.recordPublish(time.Since(publishTime))
+	if len(u.requests) > 1 {
+		metrics.Count("worker_coalesced_batches_total", len(u.requests), "worker="+p.name)
+	}
+
+	var errs []error
+	timedOut := false
+	var bulkErr publisher.BulkError
+	if errors.As(err, &bulkErr) {
+		errs = bulkErr.Errors
+	} else if err != nil {
+		// the producer failed as a whole, delivery reports of the events it produced may still come
+		logger.Errorf("[worker] %s failed to produce %d events: %v", p.name, len(records), err)
+		errs = make([]error, len(records))
+		for i := range errs {
+			errs[i] = err
+		}
+		timedOut = true
+	}
+	offset := 0
+	now := time.Now()
+	for i, request := range u.requests {
+		lenBatch := int64(len(request.GetEvents()))
+		totalErr := 0
+		for j := offset; j < offset+int(lenBatch) && j < len(errs); j++ {
+			if errs[j] != nil {
+				logger.Errorf("[worker] Fail to publish message of %s to kafka %v", request.ConnectionIdentifier, errs[j])
+				totalErr++
+				timedOut = timedOut || errors.Is(errs[j], publisher.ErrDeliveryTimeout)
 			}
-			w.wg.Done()
-		}(fmt.Sprintf("worker-%d", i))
+		}
+		offset += int(lenBatch)
+		logger.Debug(fmt.Sprintf("Success sending messages, %v", lenBatch-int64(totalErr)))
+		if lenBatch > 0 {
+			eventTimingMs := time.Since(request.GetSentTime().AsTime().Add(request.ClockOffset)).Milliseconds() / lenBatch
+			metrics.Timing("event_processing_duration_milliseconds", eventTimingMs, fmt.Sprintf("conn_group=%s", request.ConnectionIdentifier.Group))
+			metrics.Timing("worker_processing_duration_milliseconds", (now.Sub(u.readTimes[i]).Milliseconds())/lenBatch, "worker="+p.name)
+			metrics.Timing("server_processing_latency_milliseconds", (now.Sub(request.TimeConsumed)).Milliseconds()/lenBatch, fmt.Sprintf("conn_group=%s", request.ConnectionIdentifier.Group))
+		}
+	}
+	if timedOut {
+		// the publisher keeps draining the late delivery reports from the previous channel
+		p.deliveryChan = make(chan kafka.Event, p.pool.deliveryChannelSize)
 	}
 }
 
+// next returns the next batch to process, or false when the worker should stop. Returns lingered when linger fires first.
+// While the pool is paused, next waits for it to be resumed before reading events. Once flushing timed out, next
+// returns false right away.
+func (w *Pool) next(events <-chan collection.CollectRequest, flushing, retire <-chan struct{}, linger <-chan time.Time) (request collection.CollectRequest, lingered bool, ok bool) {
+	for {
+		if w.ctx.Err() != nil {
+			return collection.CollectRequest{}, false, false
+		}
+		paused, resumed := w.pauseState()
+		if resumed != nil {
+			select {
+			case <-resumed:
+				continue
+			case <-retire:
+				return collection.CollectRequest{}, false, false
+			case <-linger:
+				return collection.CollectRequest{}, true, true
+			}
+		}
+		select {
+		case <-paused:
+			continue
+		case <-w.ctx.Done():
+			continue
+		case <-retire:
+			return collection.CollectRequest{}, false, false
+		case request, ok := <-events:
+			return request, false, ok
+		case <-linger:
+			return collection.CollectRequest{}, true, true
+		case <-flushing:
+			select {
+			case request, ok := <-events:
+				return request, false, ok
+			default:
+				return collection.CollectRequest{}, false, false
+			}
+		}
+	}
+}
+
+// batchContext returns the context bounding the publishing of a single batch.
+func (w *Pool) batchContext() (context.Context, context.CancelFunc) {
+	if w.deliveryTimeout > 0 {
+		return context.WithTimeout(w.ctx, w.deliveryTimeout)
+	}
+	return context.WithCancel(w.ctx)
+}
+
 // FlushWithTimeOut waits for the workers to complete the pending the messages
-//to be flushed to the publisher within a timeout.
-// Returns true if waiting timed out, meaning not all the events could be processed before this timeout.
+//to be flushed to the publisher within a timeout. Workers stop once EventsChannel is empty.
+// Returns true if waiting timed out, meaning not all the events could be processed before this timeout. The workers
+// are then cancelled and waited for up to stopTimeout, the requests they did not publish are left to Unprocessed.
 func (w *Pool) FlushWithTimeOut(timeout time.Duration) bool {
+	if w.scalerDone != nil {
+		close(w.stopScaling)
+		<-w.scalerDone
+	}
+	w.pauseMu.Lock()
+	w.stopped = false
+	w.resume()
+	w.pauseMu.Unlock()
+	close(w.flushing)
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
@@ -83,6 +347,12 @@ func (w *Pool) FlushWithTimeOut(timeout time.Duration) bool {
 	case <-c:
 		return false // completed normally
 	case <-time.After(timeout):
+		w.cancel()
+		select {
+		case <-c:
+		case <-time.After(stopTimeout):
+			logger.Errorf("[worker] workers still running %v after flushing timed out", stopTimeout)
+		}
 		return true // timed out
 	}
 }
@@ -1,7 +1,7 @@
 package worker
 
 import (
-	"sync"
+	"context"
 	"testing"
 	"time"
 
@@ -32,13 +32,7 @@ func TestWorker(t *testing.T) {
 			m.On("Count", "kafka_messages_delivered_total", 0, "success=true")
 			m.On("Count", "kafka_messages_delivered_total", 0, "success=false")
 			bc := make(chan collection.CollectRequest, 2)
-			worker := Pool{
-				Size:                1,
-				deliveryChannelSize: 0,
-				EventsChannel:       bc,
-				kafkaProducer:       &kp,
-				wg:                  sync.WaitGroup{},
-			}
+			worker := CreateWorkerPool(1, bc, 0, 0, mockProducerPool{&kp})
 			worker.StartWorkers()
 
 			kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
@@ -59,13 +53,7 @@ func TestWorker(t *testing.T) {
 			m.On("Count", "kafka_messages_delivered_total", 0, "success=false")
 			m.On("Count", "kafka_messages_delivered_total", 0, "success=true")
 
-			worker := Pool{
-				Size:                1,
-				deliveryChannelSize: 100,
-				EventsChannel:       bc,
-				kafkaProducer:       &kp,
-				wg:                  sync.WaitGroup{},
-			}
+			worker := CreateWorkerPool(1, bc, 100, 0, mockProducerPool{&kp})
 			worker.StartWorkers()
 			kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Times(3).After(3 * time.Millisecond)
 			bc <- *request
@@ -77,5 +65,60 @@ func TestWorker(t *testing.T) {
 			assert.Equal(t, 0, len(bc))
 			kp.AssertExpectations(t)
 		})
+
+		t.Run("Should stop the workers once the channel is empty", func(t *testing.T) {
+			kp := mockKafkaPublisher{}
+			bc := make(chan collection.CollectRequest, 2)
+			worker := CreateWorkerPool(2, bc, 100, 0, mockProducerPool{&kp})
+			worker.StartWorkers()
+			kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
+			bc <- *request
+			bc <- *request
+			timedOut := worker.FlushWithTimeOut(1 * time.Second)
+			assert.False(t, timedOut)
+			assert.Equal(t, 0, len(bc))
+			kp.AssertExpectations(t)
+		})
+
+		t.Run("Should leave the units not published to Unprocessed once timed out", func(t *testing.T) {
+			kp := mockKafkaPublisher{}
+			bc := make(chan collection.CollectRequest, 2)
+			worker := CreateWorkerPool(1, bc, 100, 0, mockProducerPool{&kp})
+			worker.EnableCoalescing(CoalescingConfig{MaxEvents: 10, MaxBytes: 100, Linger: time.Hour})
+			kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+				<-worker.ctx.Done()
+			})
+			worker.StartWorkers()
+			bc <- requestOf("viewer", "a")
+			bc <- requestOf("driver", "b")
+			assert.Eventually(t, func() bool { return len(bc) == 0 }, time.Second, time.Millisecond)
+
+			assert.True(t, worker.FlushWithTimeOut(20*time.Millisecond))
+			kp.AssertNumberOfCalls(t, "ProduceBulk", 1)
+			assert.Equal(t, 0, worker.Status().Busy)
+			unprocessed := worker.Unprocessed()
+			assert.Len(t, unprocessed, 1)
+			assert.Empty(t, worker.Unprocessed())
+		})
+
+		t.Run("Should publish the batches still queued in a low weight group of the fair queue", func(t *testing.T) {
+			kp := mockKafkaPublisher{}
+			kp.On("ProduceBulk", mock.Anything, mock.Anything, mock.Anything).Return(nil).After(time.Millisecond)
+			bc := make(chan collection.CollectRequest, 1)
+			fq := collection.NewFairQueue(bc, 10, map[string]int{"viewer": 10}, 1, collection.Backpressure{})
+			go fq.Run()
+			for i := 0; i < 5; i++ {
+				viewer, driver := requestOf("viewer", "a"), requestOf("driver", "b")
+				assert.NoError(t, fq.Collect(context.Background(), &viewer))
+				assert.NoError(t, fq.Collect(context.Background(), &driver))
+			}
+			worker := CreateWorkerPool(1, bc, 100, 0, mockProducerPool{&kp})
+			worker.StartWorkers()
+
+			assert.Empty(t, fq.Flush(time.Second))
+			assert.False(t, worker.FlushWithTimeOut(time.Second))
+			kp.AssertNumberOfCalls(t, "ProduceBulk", 10)
+			assert.Equal(t, 0, fq.Pending())
+		})
 	})
 }